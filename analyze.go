@@ -2,10 +2,10 @@ package tmpl
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"html/template"
 	"reflect"
+	"sort"
 	"strings"
 	"text/template/parse"
 )
@@ -28,12 +28,33 @@ type AnalysisHelper struct {
 	// fieldTree is a tree structure of all struct fields in the TemplateProvider,
 	// as well as all of its children.
 	fieldTree *FieldNode
+	// providers is a map of template names to the struct types of the
+	// TemplateProviders that define them.
+	providers map[string]reflect.Type
+	// providerNames are the keys of providers in the order the
+	// TemplateProviders were found, starting with the analysis target.
+	providerNames []string
+	// layouts is a map of the names of the layouts of the analysis target to
+	// the name of the template rendered by their outlet.
+	layouts map[string]string
+	// owners is a map of template names to the struct types of the
+	// TemplateProviders whose text defines them.
+	owners map[string]reflect.Type
+	// duplicates records the names of templates and the struct types of the
+	// TemplateProviders that redefined them, so each is only reported once.
+	duplicates map[string]bool
+	// undeclaredSlots are the parse trees of the slots that override a block
+	// no template declares.
+	undeclaredSlots []*parse.Tree
+	// sources is a map of template names to the file path their text was
+	// loaded from, if known via TemplateSourceProvider.
+	sources map[string]string
+	// tree is the parse.Tree currently being analyzed.
+	tree *parse.Tree
 
 	//analysis data
-	// errors is a slice of Errors that occurred during analysis.
-	errors []string
-	// warnings is a slice of Warnings that occurred during analysis.
-	warnings []string
+	// diagnostics is a slice of errors and warnings reported during analysis.
+	diagnostics []Diagnostic
 	// funcMap is a map of functions provided by analyzers that should
 	// be added before the template is executed.
 	funcMap template.FuncMap
@@ -53,7 +74,22 @@ func (h *AnalysisHelper) IsDefinedTemplate(name string) bool {
 	return ok
 }
 
+// IsLayout returns true if the template with the given name is the layout of
+// the analysis target or of any of its layouts.
+func (h *AnalysisHelper) IsLayout(name string) bool {
+	_, ok := h.layouts[name]
+	return ok
+}
+
+// GetDefinedField returns the FieldNode at the given path relative to the
+// struct of the template currently being analyzed. It returns nil if the field
+// is not defined or if the type of dot is not known, as is the case in the
+// body of a {{ define }} block.
 func (h *AnalysisHelper) GetDefinedField(name string) *FieldNode {
+	if h.fieldTree == nil {
+		return nil
+	}
+
 	name = strings.TrimPrefix(name, ".")
 	if len(name) == 0 {
 		return h.fieldTree
@@ -65,13 +101,44 @@ func (h *AnalysisHelper) FuncMap() FuncMap {
 	return h.funcMap
 }
 
+// AddError reports an error at the location of the given node.
 func (h *AnalysisHelper) AddError(node parse.Node, err string) {
-	// TODO: to get a useful error message, convert byte position (offset) to line numbers
-	h.errors = append(h.errors, fmt.Sprintf("%v: %s", node.Position(), err))
+	h.AddDiagnostic(node, SeverityError, "", err)
 }
 
+// AddWarning reports a warning at the location of the given node.
 func (h *AnalysisHelper) AddWarning(node parse.Node, err string) {
-	h.warnings = append(h.warnings, fmt.Sprintf("%v: %s", node.Position(), err))
+	h.AddDiagnostic(node, SeverityWarning, "", err)
+}
+
+// AddDiagnostic reports a problem with the given severity at the location of
+// the given node. The code is the ID of the rule reporting the problem.
+// The same template body can be analyzed more than once, so a Diagnostic that
+// has already been reported is ignored.
+func (h *AnalysisHelper) AddDiagnostic(node parse.Node, severity Severity, code string, msg string) {
+	diagnostic := newDiagnostic(h.tree, node, h.sources, severity, code, msg)
+	for _, d := range h.diagnostics {
+		if d == diagnostic {
+			return
+		}
+	}
+	h.diagnostics = append(h.diagnostics, diagnostic)
+}
+
+// Diagnostics returns all errors and warnings reported during analysis
+// in the order they were reported.
+func (h *AnalysisHelper) Diagnostics() []Diagnostic {
+	return h.diagnostics
+}
+
+// HasErrors returns true if any Diagnostic with SeverityError was reported.
+func (h *AnalysisHelper) HasErrors() bool {
+	for _, d := range h.diagnostics {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
 }
 
 func (h *AnalysisHelper) AddFunc(name string, fn interface{}) {
@@ -94,41 +161,124 @@ type ParseOptions struct {
 	Funcs      FuncMap
 	LeftDelim  string
 	RightDelim string
+
+	// Namespaces prefixes the names of the templates defined in the text of
+	// a nested TemplateProvider with the name of the nested template, so that
+	// {{ define "input" }} in the template named "form" defines "form.input".
+	Namespaces bool
+
+	// ConcreteTypes makes Analyze trust the values of the given TemplateProvider:
+	// interface fields holding a non-nil value are checked against the type
+	// of that value rather than the method set declared by the interface.
+	ConcreteTypes bool
 }
 
 type AnalyzerFunc func(val reflect.Value, node parse.Node)
 
-// Analyzer is a type that parses templateProvider text and performs an analysis
+// Analyzer is a type that parses templateProvider text and performs an analysis.
+// An Analyzer is called once per analysis before any nodes are visited, so
+// functions added via AnalysisHelper.AddFunc at that point are known to the
+// builtin static type checker.
 type Analyzer func(res *AnalysisHelper) AnalyzerFunc
 
 // Analyze uses reflection on the given TemplateProvider while also parsing the
 // templateProvider text to perform an analysis. The analysis is performed by the given
 // analyzers. The analysis is returned as an AnalysisHelper struct.
+//
+// The template of every nested TemplateProvider is analyzed against its own
+// struct type, except for layouts, which are rendered with the data of the
+// page they wrap and are analyzed against the type of the given
+// TemplateProvider. The body of every {{ define }} block is analyzed with an
+// unknown type of dot. The builtin static type checker additionally checks
+// the body of a nested template against the type of the data it is invoked with.
+//
+// If any errors are reported during the analysis, an *AnalysisError containing
+// all reported Diagnostics is returned alongside the AnalysisHelper.
 func Analyze(tp TemplateProvider, opts ParseOptions, analyzers []Analyzer) (*AnalysisHelper, error) {
 	helper, err := createHelper(tp, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	pt := helper.treeSet[strings.TrimPrefix(fmt.Sprintf("%T", tp), "*")]
-	val := reflect.ValueOf(tp)
+	rootTree := helper.treeSet[strings.TrimPrefix(fmt.Sprintf("%T", tp), "*")]
+	rootFieldTree := helper.fieldTree
+
+	for _, tree := range helper.undeclaredSlots {
+		helper.tree = tree
+		helper.AddDiagnostic(tree.Root, SeverityError, CodeUndeclaredSlot, fmt.Sprintf("slot %q overrides a block that is not declared by any template: declare it with {{ block %q . }}", tree.Name, tree.Name))
+	}
+	helper.tree = rootTree
+
+	fns := make([]AnalyzerFunc, 0, len(analyzers))
+	for _, analyzer := range analyzers {
+		fns = append(fns, analyzer(helper))
+	}
+
+	analyze := func(tree *parse.Tree, val reflect.Value, fieldTree *FieldNode) {
+		helper.tree = tree
+		helper.fieldTree = fieldTree
+
+		// Do the actual traversal and analysis of the given parse tree
+		Traverse(tree.Root, Visitor(func(node parse.Node) {
+			for _, fn := range fns {
+				fn(val, node)
+			}
+		}))
+	}
+
+	analyze(rootTree, reflect.ValueOf(tp), rootFieldTree)
+
+	// Nested TemplateProviders are registered under their tag name as well
+	// as their type name, so each struct type is only analyzed once
+	analyzed := map[reflect.Type]bool{reflect.TypeOf(tp): true}
+	for _, name := range helper.providerNames {
+		typ := helper.providers[name]
+		tree, ok := helper.treeSet[name]
+		if analyzed[typ] || !ok || tree.Root == nil {
+			continue
+		}
+		analyzed[typ] = true
+
+		// layouts are rendered with the data of the page they wrap
+		if helper.IsLayout(name) {
+			analyze(tree, reflect.ValueOf(tp), rootFieldTree)
+			continue
+		}
+
+		val := reflect.New(indirectType(typ))
+		fieldTree, err := createFieldTree(val.Interface())
+		if err != nil {
+			return nil, err
+		}
+		analyze(tree, val, fieldTree)
+	}
+
+	// The type of dot in a {{ define }} block depends on where it is invoked
+	defines := make([]string, 0)
+	for name := range helper.treeSet {
+		if _, ok := helper.providers[name]; !ok {
+			defines = append(defines, name)
+		}
+	}
+	sort.Strings(defines)
 
-	// Do the actual traversal and analysis of the given template provider
-	Traverse(pt.Root, Visitor(func(node parse.Node) {
-		for _, fn := range analyzers {
-			fn(helper)(val, node)
+	for _, name := range defines {
+		tree := helper.treeSet[name]
+		typ, ok := helper.providers[tree.ParseName]
+		if !ok || tree.Root == nil {
+			continue
 		}
-	}))
+		analyze(tree, reflect.New(indirectType(typ)), nil)
+	}
+
+	helper.tree = rootTree
+	helper.fieldTree = rootFieldTree
 
 	// During runtime compilation we're only worried about errors
 	// During static analysis we're worried about errors but also
 	//   return the helper to print warnings and other information
-	if len(helper.errors) > 0 {
-		errs := make([]error, 0)
-		for _, err := range helper.errors {
-			errs = append(errs, fmt.Errorf(err))
-		}
-		return helper, errors.Join(errs...)
+	if helper.HasErrors() {
+		return helper, &AnalysisError{Diagnostics: helper.Diagnostics()}
 	}
 
 	return helper, nil
@@ -136,12 +286,22 @@ func Analyze(tp TemplateProvider, opts ParseOptions, analyzers []Analyzer) (*Ana
 
 func createHelper(tp TemplateProvider, opts ParseOptions) (helper *AnalysisHelper, err error) {
 	helper = &AnalysisHelper{
-		ctx:     context.Background(),
-		treeSet: make(map[string]*parse.Tree),
+		ctx:        context.Background(),
+		treeSet:    make(map[string]*parse.Tree),
+		sources:    make(map[string]string),
+		providers:  make(map[string]reflect.Type),
+		owners:     make(map[string]reflect.Type),
+		duplicates: make(map[string]bool),
+
+		diagnostics: make([]Diagnostic, 0),
+		funcMap:     DefaultFuncs(),
+	}
 
-		errors:   make([]string, 0),
-		warnings: make([]string, 0),
-		funcMap:  opts.Funcs,
+	helper.funcMap[flushFuncName] = noopFlush
+	helper.funcMap[contextFuncName] = contextFunc(context.Background())
+	helper.funcMap[outletFuncName] = unwiredOutlet
+	for k, v := range opts.Funcs {
+		helper.funcMap[k] = v
 	}
 
 	if len(opts.LeftDelim) == 0 || len(opts.RightDelim) == 0 {
@@ -149,19 +309,32 @@ func createHelper(tp TemplateProvider, opts ParseOptions) (helper *AnalysisHelpe
 		opts.RightDelim = "}}"
 	}
 
+	helper.layouts, _, err = resolveLayouts(tp, strings.TrimPrefix(fmt.Sprintf("%T", tp), "*"))
+	if err != nil {
+		return nil, err
+	}
+
 	// create a tree of all fields for static type checking
-	helper.fieldTree, err = createFieldTree(tp)
+	if opts.ConcreteTypes {
+		helper.fieldTree, err = createConcreteFieldTree(tp)
+	} else {
+		helper.fieldTree, err = createFieldTree(tp)
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	// create one big parse.Tree set of all templates, including embedded templates
+	isSlot := slotFilter()
+	isRoot := true
 	err = recurseFieldsImplementing[TemplateProvider](tp, func(tp TemplateProvider, field reflect.StructField) error {
-		templateName, ok := field.Tag.Lookup("tmpl")
-		if !ok {
-			templateName = strings.TrimPrefix(field.Name, "*")
+		// slots are added last, so that they replace the blocks they override
+		if isSlot(tp, field) {
+			return nil
 		}
 
+		templateName := templateNameOf(field, strings.TrimPrefix(field.Name, "*"))
+
 		parser := parse.New(templateName)
 		parser.Mode = parse.SkipFuncCheck | parse.ParseComments
 
@@ -171,12 +344,58 @@ func createHelper(tp TemplateProvider, opts ParseOptions) (helper *AnalysisHelpe
 			return err
 		}
 
+		if opts.Namespaces && !isRoot {
+			tmp = namespaceTrees(templateName, tmp)
+		}
+		isRoot = false
+
+		if sp, ok := tp.(TemplateSourceProvider); ok {
+			helper.sources[templateName] = sp.TemplateSource()
+		}
+
 		for k, v := range tmp {
-			helper.treeSet[k] = v
+			helper.addTree(k, v, reflect.TypeOf(tp))
+		}
+
+		if _, ok := helper.providers[templateName]; !ok {
+			helper.providerNames = append(helper.providerNames, templateName)
 		}
+		helper.providers[templateName] = reflect.TypeOf(tp)
 
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	slots, err := collectSlots(tp)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range slots {
+		parser := parse.New(s.name)
+		parser.Mode = parse.SkipFuncCheck | parse.ParseComments
+
+		tmp := make(map[string]*parse.Tree)
+		_, err := parser.Parse(s.text, opts.LeftDelim, opts.RightDelim, tmp, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		// only blocks can be overridden, not the templates of providers
+		_, isBlock := helper.treeSet[s.name]
+		if _, ok := helper.providers[s.name]; ok || !isBlock {
+			helper.undeclaredSlots = append(helper.undeclaredSlots, tmp[s.name])
+			continue
+		}
+
+		for k, v := range tmp {
+			helper.treeSet[k] = v
+		}
+		if len(s.source) != 0 {
+			helper.sources[s.name] = s.source
+		}
+	}
 
 	return
 }
@@ -0,0 +1,128 @@
+package tmpl
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/tylermmorton/tmpl/testdata"
+)
+
+func Test_Analyze(t *testing.T) {
+	testTable := []struct {
+		name             string
+		templateProvider TemplateProvider
+		parseOpts        ParseOptions
+		wantDiagnostics  []Diagnostic
+	}{
+		{
+			name:             "Reports structured diagnostics for undefined fields",
+			templateProvider: &SourcedUndefinedField{},
+			wantDiagnostics: []Diagnostic{
+				{
+					Severity: SeverityError,
+					Code:     CodeUndefinedField,
+					Template: "testdata.SourcedUndefinedField",
+					File:     "testdata/undefined.tmpl.html",
+					Line:     2,
+					Column:   6,
+					Message:  "field \".UndField\" not defined in struct *testdata.SourcedUndefinedField",
+					Node:     ".UndField",
+				},
+			},
+		},
+		{
+			name:             "Reports structured diagnostics for non-bool conditions",
+			templateProvider: &AnyTypeIf{DefIf: 0},
+			parseOpts:        ParseOptions{ConcreteTypes: true},
+			wantDiagnostics: []Diagnostic{
+				{
+					Severity: SeverityError,
+					Code:     CodeNonBoolCondition,
+					Template: "testdata.AnyTypeIf",
+					Line:     1,
+					Column:   7,
+					Message:  "field \".DefIf\" is not type bool: got int",
+					Node:     "{{if .DefIf}}{{end}}",
+				},
+			},
+		},
+		{
+			name:             "Reports warnings for conditions of type any",
+			templateProvider: &AnyTypeIf{DefIf: 0},
+			wantDiagnostics: []Diagnostic{
+				{
+					Severity: SeverityWarning,
+					Code:     CodeNonBoolCondition,
+					Template: "testdata.AnyTypeIf",
+					Line:     1,
+					Column:   7,
+					Message:  "field \".DefIf\" may not be type bool: got interface",
+					Node:     "{{if .DefIf}}{{end}}",
+				},
+			},
+		},
+		{
+			name:             "Reports warnings for fields of values of type any",
+			templateProvider: &AnyField{},
+			wantDiagnostics: []Diagnostic{
+				{
+					Severity: SeverityWarning,
+					Code:     CodeDynamicType,
+					Template: "testdata.AnyField",
+					Line:     1,
+					Column:   8,
+					Message:  "field \".Any.Name\" cannot be checked: it is accessed through a value of type interface {}",
+					Node:     ".Any.Name",
+				},
+			},
+		},
+		{
+			name:             "Reports structured diagnostics for undefined variables",
+			templateProvider: &AssignedUndeclaredVariable{},
+			wantDiagnostics: []Diagnostic{
+				{
+					Severity: SeverityError,
+					Code:     CodeUndefinedVariable,
+					Template: "testdata.AssignedUndeclaredVariable",
+					Line:     1,
+					Column:   4,
+					Message:  "variable \"$x\" is not defined",
+					Node:     "$x",
+				},
+			},
+		},
+		{
+			name:             "Reports no diagnostics for valid templates",
+			templateProvider: &DefinedField{},
+			wantDiagnostics:  []Diagnostic{},
+		},
+	}
+
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			helper, err := Analyze(tt.templateProvider, tt.parseOpts, builtinAnalyzers)
+
+			wantErr := false
+			for _, d := range tt.wantDiagnostics {
+				wantErr = wantErr || d.Severity == SeverityError
+			}
+
+			var analysisErr *AnalysisError
+			if wantErr && !errors.As(err, &analysisErr) {
+				t.Fatalf("Analyze() expected *AnalysisError, got %v", err)
+			} else if !wantErr && err != nil {
+				t.Fatalf("Analyze() unexpected error: %v", err)
+			}
+
+			got := helper.Diagnostics()
+			if len(got) != len(tt.wantDiagnostics) {
+				t.Fatalf("Analyze() expected %d diagnostics, got %d: %+v", len(tt.wantDiagnostics), len(got), got)
+			}
+			for i, want := range tt.wantDiagnostics {
+				if got[i] != want {
+					t.Errorf("Analyze() diagnostic %d:\n\twant %#v\n\tgot  %#v", i, want, got[i])
+				}
+			}
+		})
+	}
+}
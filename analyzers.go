@@ -4,13 +4,16 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"strings"
+	"sync"
 	"text/template/parse"
 )
 
 type key string
 
 const (
-	visitedMapKey key = "visited"
+	visitedMapKey    key = "visited"
+	templateStackKey key = "templateStack"
 )
 
 func setVisited(ctx context.Context, node parse.Node) context.Context {
@@ -29,166 +32,724 @@ func isVisited(ctx context.Context, node parse.Node) bool {
 	return false
 }
 
+// Rule codes of the Diagnostics reported by the builtin analyzers
+const (
+	CodeUndefinedField      = "undefined-field"
+	CodeNonBoolCondition    = "non-bool-condition"
+	CodeInvalidArguments    = "invalid-arguments"
+	CodeIncompatibleTypes   = "incompatible-types"
+	CodeUndefinedTemplate   = "undefined-template"
+	CodeMissingPipeline     = "missing-pipeline"
+	CodeUndefinedVariable   = "undefined-variable"
+	CodeInvalidRange        = "invalid-range"
+	CodeUndefinedFunction   = "undefined-function"
+	CodeInvalidTemplateData = "invalid-template-data"
+	CodeInvalidResults      = "invalid-results"
+	CodeDynamicType         = "dynamic-type"
+	CodeInvalidOutlet       = "invalid-outlet"
+	CodeUndeclaredSlot      = "undeclared-slot"
+	CodeDuplicateTemplate   = "duplicate-template"
+)
+
 var builtinAnalyzers = []Analyzer{
 	staticTyping,
+	layoutOutlets,
+}
+
+var (
+	registeredMu        sync.RWMutex
+	registeredAnalyzers = make([]Analyzer, 0)
+)
+
+// RegisterAnalyzers registers analyzers that are run by default whenever a
+// template is compiled, in addition to the builtin analyzers. It is meant to
+// be called from an init function so that the analyzers are also picked up by
+// the `tmpl check` command.
+func RegisterAnalyzers(analyzers ...Analyzer) {
+	registeredMu.Lock()
+	defer registeredMu.Unlock()
+	registeredAnalyzers = append(registeredAnalyzers, analyzers...)
+}
+
+// DefaultAnalyzers returns the builtin analyzers followed by all analyzers
+// registered via RegisterAnalyzers.
+func DefaultAnalyzers() []Analyzer {
+	registeredMu.RLock()
+	defer registeredMu.RUnlock()
+
+	analyzers := make([]Analyzer, 0, len(builtinAnalyzers)+len(registeredAnalyzers))
+	analyzers = append(analyzers, builtinAnalyzers...)
+	analyzers = append(analyzers, registeredAnalyzers...)
+	return analyzers
 }
 
-func staticTypingRecursive(prefix string, val reflect.Value, node parse.Node, helper *AnalysisHelper) {
+func staticTypingRecursive(s *scope, val reflect.Value, node parse.Node, helper *AnalysisHelper) {
+	if node == nil || isVisited(helper.ctx, node) {
+		return
+	}
+	helper.WithContext(setVisited(helper.Context(), node))
+
 	switch nodeTyp := node.(type) {
+	case *parse.ListNode:
+		for _, child := range nodeTyp.Nodes {
+			staticTypingRecursive(s, val, child, helper)
+		}
+
+	case *parse.ActionNode:
+		typ := typeOfPipe(s, val, nodeTyp.Pipe, helper)
+		declarePipe(s, nodeTyp.Pipe, typ, helper)
+
 	case *parse.IfNode:
-		for _, cmd := range nodeTyp.Pipe.Cmds {
-			if len(cmd.Args) == 1 {
-				switch argTyp := cmd.Args[0].(type) {
-				case *parse.FieldNode:
-					if isVisited(helper.ctx, argTyp) {
-						break
-					}
-					typ := prefix + argTyp.String()
-					field := helper.GetDefinedField(typ)
-					if field == nil {
-						helper.AddError(node, fmt.Sprintf("field %q not defined in struct %T", typ, val.Interface()))
-					} else if kind, ok := field.IsKind(reflect.Bool); !ok {
-						helper.AddError(node, fmt.Sprintf("field %q is not type bool: got %s", typ, kind))
-					}
-					helper.WithContext(setVisited(helper.Context(), argTyp))
+		// variables declared in the pipeline are visible in both branches
+		inner := s.child(s.dot, s.path)
+		typ := typeOfPipe(inner, val, nodeTyp.Pipe, helper)
+		declarePipe(inner, nodeTyp.Pipe, typ, helper)
+
+		// check that bare fields and variables used as conditions are bools:
+		// {{ if .Field }} or {{ if $var }}
+		if len(nodeTyp.Pipe.Cmds) == 1 && len(nodeTyp.Pipe.Cmds[0].Args) == 1 && typ != nil {
+			// values of empty interface types may hold a bool
+			severity, verb := SeverityError, "is not"
+			if isEmptyInterface(typ.Type()) {
+				severity, verb = SeverityWarning, "may not be"
+			}
+
+			switch argTyp := nodeTyp.Pipe.Cmds[0].Args[0].(type) {
+			case *parse.FieldNode:
+				if kind, ok := typ.IsKind(reflect.Bool); !ok {
+					helper.AddDiagnostic(node, severity, CodeNonBoolCondition, fmt.Sprintf("field %q %s type bool: got %s", s.path+argTyp.String(), verb, kind))
 				}
-			} else {
-				// this is a pipeline like {{ if eq .Arg "foo" }}
-				if arg, ok := cmd.Args[0].(*parse.IdentifierNode); ok {
-					if isVisited(helper.ctx, arg) {
-						continue
-					}
-
-					switch arg.Ident {
-					// TODO: generalize this to all function calls instead of just builtins
-					case "eq", "ne", "lt", "le", "gt", "ge":
-						if len(cmd.Args) != 3 {
-							helper.AddError(node, fmt.Sprintf("invalid number of arguments for %q: expected 3, got %d", arg.Ident, len(cmd.Args)))
-						}
-
-						kind := make([]reflect.Kind, 2)
-						for i, arg := range cmd.Args[1:] {
-							switch argTyp := arg.(type) {
-							case *parse.FieldNode:
-								typ := prefix + argTyp.String()
-								field := helper.GetDefinedField(typ)
-								if field == nil && !isVisited(helper.ctx, argTyp) {
-									helper.AddError(node, fmt.Sprintf("field %q not defined in struct %T", typ, val.Interface()))
-									helper.WithContext(setVisited(helper.Context(), argTyp))
-								} else if field != nil {
-									kind[i] = field.GetKind()
-								}
-								break
-
-							case *parse.VariableNode:
-								// account for the root scope ($)
-								if argTyp.Ident[0] == "$" {
-									typ := argTyp.Ident[1]
-									field := helper.GetDefinedField(typ)
-									if field == nil && !isVisited(helper.ctx, argTyp) {
-										helper.AddError(node, fmt.Sprintf("field %q not defined in struct %T", typ, val.Interface()))
-										helper.WithContext(setVisited(helper.Context(), argTyp))
-									} else if field != nil {
-										kind[i] = field.GetKind()
-									}
-								}
-							case *parse.StringNode:
-								kind[i] = reflect.String
-								break
-
-							case *parse.NumberNode:
-								if argTyp.IsInt {
-									kind[i] = reflect.Int
-								} else if argTyp.IsFloat {
-									kind[i] = reflect.Float32 // TODO: will this break on Float64?
-								} else if argTyp.IsUint {
-									kind[i] = reflect.Uint
-								} else if argTyp.IsComplex {
-									kind[i] = reflect.Complex64
-								}
-							}
-						}
-						// check if arg1 and arg2 are comparable
-						if kind[0] != kind[1] {
-							// TODO(tylermmorton): there's a bug here where the helper
-							//  isn't detecting the correct kind of the field
-							//helper.AddError(node, fmt.Sprintf("incompatible types for %q: %s and %s", arg.Ident, kind[0], kind[1]))
-						}
-					}
-
-					helper.WithContext(setVisited(helper.Context(), arg))
+			case *parse.VariableNode:
+				if kind, ok := typ.IsKind(reflect.Bool); !ok {
+					helper.AddDiagnostic(node, severity, CodeNonBoolCondition, fmt.Sprintf("variable %q %s type bool: got %s", argTyp.String(), verb, kind))
 				}
 			}
 		}
-		break
+
+		staticTypingRecursive(inner.child(s.dot, s.path), val, nodeTyp.List, helper)
+		if nodeTyp.ElseList != nil {
+			staticTypingRecursive(inner.child(s.dot, s.path), val, nodeTyp.ElseList, helper)
+		}
 
 	case *parse.RangeNode:
-		// TODO: this will break for {{ range }} statements with assignments:
-		//  {{ $i, $v := range .Arg }}
-		var inferTyp = prefix
-		// check the type of the argument passed to range: {{ range .Arg }}
-		for _, cmd := range nodeTyp.Pipe.Cmds {
-			for _, arg := range cmd.Args {
-				switch argTyp := arg.(type) {
-				case *parse.FieldNode:
-					if isVisited(helper.ctx, argTyp) {
-						break
-					}
-					inferTyp = prefix + argTyp.String()
-					field := helper.GetDefinedField(inferTyp)
-					if field == nil {
-						helper.AddError(node, fmt.Sprintf("field %q not defined in struct %T", argTyp.String(), val.Interface()))
-					}
-					helper.WithContext(setVisited(helper.Context(), argTyp))
-				}
+		inner := s.child(s.dot, s.path)
+		typ := typeOfPipe(inner, val, nodeTyp.Pipe, helper)
+
+		var key, elem *FieldNode
+		if typ != nil {
+			var ok bool
+			key, elem, ok = rangeTypes(typ)
+			if !ok {
+				helper.AddDiagnostic(node, SeverityError, CodeInvalidRange, fmt.Sprintf("range can't iterate over %q of type %s", pathOfPipe(s, nodeTyp.Pipe), typ.Type()))
+			} else if key == nil && elem != nil && len(nodeTyp.Pipe.Decl) > 1 {
+				helper.AddDiagnostic(node, SeverityError, CodeInvalidRange, fmt.Sprintf("range can't iterate over %q of type %s with more than one variable", pathOfPipe(s, nodeTyp.Pipe), typ.Type()))
 			}
 		}
 
+		// declare the variables of the range pipeline:
+		//  {{ range $v := .Arg }} or {{ range $i, $v := .Arg }}
+		switch len(nodeTyp.Pipe.Decl) {
+		case 1:
+			declarePipe(inner, nodeTyp.Pipe, elem, helper)
+		case 2:
+			inner.declare(nodeTyp.Pipe.Decl[0].Ident[0], key)
+			inner.declare(nodeTyp.Pipe.Decl[1].Ident[0], elem)
+		}
+
 		// recurse on the body of the range loop using the inferred type
-		Traverse(nodeTyp.List, func(node parse.Node) {
-			staticTypingRecursive(inferTyp, val, node, helper)
-		})
+		staticTypingRecursive(inner.child(elem, pathOfPipe(s, nodeTyp.Pipe)), val, nodeTyp.List, helper)
+		if nodeTyp.ElseList != nil {
+			staticTypingRecursive(inner.child(s.dot, s.path), val, nodeTyp.ElseList, helper)
+		}
+
+	case *parse.WithNode:
+		inner := s.child(s.dot, s.path)
+		typ := typeOfPipe(inner, val, nodeTyp.Pipe, helper)
+		declarePipe(inner, nodeTyp.Pipe, typ, helper)
 
-		break
+		// dot is rebound to the value of the pipeline in the body of with,
+		// the else branch (including {{ else with }}) keeps the outer dot
+		staticTypingRecursive(inner.child(typ, pathOfPipe(s, nodeTyp.Pipe)), val, nodeTyp.List, helper)
+		if nodeTyp.ElseList != nil {
+			staticTypingRecursive(inner.child(s.dot, s.path), val, nodeTyp.ElseList, helper)
+		}
 
 	case *parse.TemplateNode:
 		if !helper.IsDefinedTemplate(nodeTyp.Name) {
-			helper.AddError(node, fmt.Sprintf("template %q is not provided by struct %T or any of its embedded structs", nodeTyp.Name, val.Interface()))
+			helper.AddDiagnostic(node, SeverityError, CodeUndefinedTemplate, fmt.Sprintf("template %q is not provided by struct %T or any of its embedded structs", nodeTyp.Name, val.Interface()))
 		} else if nodeTyp.Pipe == nil {
-			helper.AddError(node, fmt.Sprintf("template %q is not invoked with a pipeline", nodeTyp.Name))
-		} else if len(nodeTyp.Pipe.Cmds) == 1 {
-			// TODO: here we can check the type of the pipeline
-			//   if the command is a DotNode, check the type of the struct for any embedded fields
-			//   if the command is a FieldNode, check the type of the field and mark it as visited
-			_ = nodeTyp.Pipe.Cmds[0]
+			helper.AddDiagnostic(node, SeverityError, CodeMissingPipeline, fmt.Sprintf("template %q is not invoked with a pipeline", nodeTyp.Name))
+		} else if typ := typeOfPipe(s, val, nodeTyp.Pipe, helper); typ != nil {
+			analyzeTemplateCall(val, nodeTyp, typ, helper)
+		}
+	}
+}
+
+// analyzeTemplateCall checks that the data passed to a nested TemplateProvider
+// via {{ template "name" pipeline }} is of the provider's struct type, or a
+// struct embedding it. The body of the nested template, or of the invoked
+// {{ define }} block, is then analyzed using the type of the passed data as dot.
+func analyzeTemplateCall(val reflect.Value, node *parse.TemplateNode, typ *FieldNode, helper *AnalysisHelper) {
+	if typ.Type() == nil || typ.Type().Kind() == reflect.Interface {
+		return
+	}
+
+	if provider, ok := helper.providers[node.Name]; ok && !embedsType(typ.Type(), provider) {
+		helper.AddDiagnostic(node, SeverityError, CodeInvalidTemplateData, fmt.Sprintf("template %q expects data of type %s: got %s", node.Name, indirectType(provider), typ.Type()))
+		return
+	}
+
+	tree, ok := helper.treeSet[node.Name]
+	if !ok {
+		return
+	}
+
+	// templates can invoke themselves recursively, so keep track of
+	// which templates are currently being analyzed with which type
+	stack, _ := helper.ctx.Value(templateStackKey).(map[string]bool)
+	if stack == nil {
+		stack = make(map[string]bool)
+	}
+	id := fmt.Sprintf("%s:%s", node.Name, typ.Type())
+	if stack[id] {
+		return
+	}
+	stack[id] = true
+	defer delete(stack, id)
+
+	// the same template can be invoked with different types, so the
+	// nested tree is analyzed with a fresh set of visited nodes
+	ctx := helper.Context()
+	defer helper.WithContext(ctx)
+	helper.WithContext(context.WithValue(context.WithValue(ctx, templateStackKey, stack), visitedMapKey, make(map[parse.Node]bool)))
+
+	if typ.Value.IsValid() {
+		val = typ.Value
+	}
+	staticTypingRecursive(newScope(typ), val, tree.Root, helper)
+}
+
+// rangeTypes returns the FieldNodes of the key and element of each iteration
+// of a range over a value of the given FieldNode's type. The key is nil for
+// types that can only be iterated with one variable. The returned bool is
+// false if the type cannot be iterated over by range.
+func rangeTypes(node *FieldNode) (key *FieldNode, elem *FieldNode, ok bool) {
+	typ := node.Type()
+	if typ == nil {
+		return nil, nil, true
+	}
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	name := node.StructField.Name
+	create := func(suffix string, typ reflect.Type) *FieldNode {
+		node, err := createFieldTreeFromType(name+suffix, typ)
+		if err != nil {
+			return nil
 		}
+		return node
+	}
+
+	switch typ.Kind() {
+	case reflect.Slice, reflect.Array:
+		return createTypeNode(name+"[key]", reflect.TypeOf(0)), create("[elem]", typ.Elem()), true
+	case reflect.Map:
+		return create("[key]", typ.Key()), create("[elem]", typ.Elem()), true
+	case reflect.Chan:
+		return nil, create("[elem]", typ.Elem()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		// range over integers was added in Go 1.22
+		return nil, createTypeNode(name+"[elem]", typ), true
+	case reflect.Func:
+		// range over iterator functions was added in Go 1.23:
+		//  func(yield func(V) bool) or func(yield func(K, V) bool)
+		if typ.NumIn() != 1 || typ.NumOut() != 0 {
+			return nil, nil, false
+		}
+		yield := typ.In(0)
+		if yield.Kind() != reflect.Func || yield.NumOut() != 1 || yield.Out(0).Kind() != reflect.Bool {
+			return nil, nil, false
+		}
+		switch yield.NumIn() {
+		case 1:
+			return nil, create("[elem]", yield.In(0)), true
+		case 2:
+			return create("[key]", yield.In(0)), create("[elem]", yield.In(1)), true
+		}
+		return nil, nil, false
+	case reflect.Interface:
+		// the dynamic type is not known until the template is executed
+		return nil, nil, true
+	}
+
+	return nil, nil, false
+}
+
+// declarePipe declares or assigns the variables of the given pipeline in the
+// given scope: {{ $x := .Field }} or {{ $x = .Field }}
+func declarePipe(s *scope, pipe *parse.PipeNode, typ *FieldNode, helper *AnalysisHelper) {
+	if pipe == nil {
+		return
+	}
+
+	for _, v := range pipe.Decl {
+		if !pipe.IsAssign {
+			s.declare(v.Ident[0], typ)
+		} else if !s.assign(v.Ident[0], typ) {
+			helper.AddDiagnostic(v, SeverityError, CodeUndefinedVariable, fmt.Sprintf("variable %q is not defined", v.Ident[0]))
+		}
+	}
+}
+
+// pathOfPipe returns the path of the value of the given pipeline relative to
+// the root of the field tree, to be used in error messages.
+func pathOfPipe(s *scope, pipe *parse.PipeNode) string {
+	if len(pipe.Cmds) == 1 && len(pipe.Cmds[0].Args) == 1 {
+		switch argTyp := pipe.Cmds[0].Args[0].(type) {
+		case *parse.DotNode:
+			return s.path
+		case *parse.FieldNode:
+			return s.path + argTyp.String()
+		}
+	}
+	return pipe.String()
+}
+
+// typeOfPipe checks all commands of the given pipeline and returns the FieldNode
+// of its final value, or nil if the type cannot be inferred.
+func typeOfPipe(s *scope, val reflect.Value, pipe *parse.PipeNode, helper *AnalysisHelper) *FieldNode {
+	if pipe == nil {
+		return nil
+	}
+
+	var typ *FieldNode
+	for i, cmd := range pipe.Cmds {
+		// the value of the previous command is passed as the last
+		// argument to each following command in the pipeline
+		typ = typeOfCommand(s, val, cmd, typ, i > 0, helper)
+	}
+	return typ
+}
+
+// typeOfCommand checks the arguments of the given command and returns the
+// FieldNode of its value, or nil if the type cannot be inferred. If piped is
+// true, prev is the FieldNode of the value passed from the previous command.
+func typeOfCommand(s *scope, val reflect.Value, cmd *parse.CommandNode, prev *FieldNode, piped bool, helper *AnalysisHelper) *FieldNode {
+	if len(cmd.Args) == 0 {
+		return nil
+	}
+
+	switch argTyp := cmd.Args[0].(type) {
+	case *parse.IdentifierNode:
+		return typeOfCall(s, val, cmd, argTyp, cmd.Args[1:], prev, piped, helper)
+	case *parse.FieldNode, *parse.ChainNode, *parse.VariableNode:
+		// methods can be called with arguments: {{ .Method "arg" 2 }}
+		return typeOfField(s, val, argTyp, cmd.Args[1:], prev, piped, helper)
+	}
+
+	for _, arg := range cmd.Args[1:] {
+		typeOfArg(s, val, arg, helper)
+	}
+	return typeOfArg(s, val, cmd.Args[0], helper)
+}
+
+// typeOfCall checks a call of the function with the given identifier against
+// its signature in the FuncMap and returns the FieldNode of its result, or nil
+// if the type cannot be inferred.
+func typeOfCall(s *scope, val reflect.Value, node parse.Node, ident *parse.IdentifierNode, args []parse.Node, prev *FieldNode, piped bool, helper *AnalysisHelper) *FieldNode {
+	fn, ok := helper.FuncMap()[ident.Ident]
+	if !ok {
+		fn, ok = builtinFuncs[ident.Ident]
+	}
+
+	fnTyp := reflect.TypeOf(fn)
+	if !ok || fnTyp == nil || fnTyp.Kind() != reflect.Func {
+		if !ok {
+			helper.AddDiagnostic(ident, SeverityError, CodeUndefinedFunction, fmt.Sprintf("function %q not defined", ident.Ident))
+		}
+		for _, arg := range args {
+			typeOfArg(s, val, arg, helper)
+		}
+		return nil
+	}
 
-		break
+	argTypes := checkCall(s, val, node, ident.Ident, fnTyp, args, prev, piped, helper)
 
-	// FieldNode is the last node that we want to check. Give a chance for analyzers
-	// higher up in the parse tree to mark them as visited.
+	switch ident.Ident {
+	case "eq", "ne", "lt", "le", "gt", "ge":
+		types := make([]reflect.Type, 0, len(argTypes))
+		for _, typ := range argTypes {
+			if typ != nil {
+				types = append(types, typ.Type())
+			} else {
+				types = append(types, nil)
+			}
+		}
+		if msg := checkComparison(ident.Ident, types); len(msg) != 0 {
+			helper.AddDiagnostic(node, SeverityError, CodeIncompatibleTypes, msg)
+		}
+
+	case "index":
+		if len(argTypes) > 0 && argTypes[0] != nil {
+			return typeOfIndex(node, argTypes[0], argTypes[1:], helper)
+		}
+		return nil
+
+	case "slice":
+		if len(argTypes) > 0 {
+			return argTypes[0]
+		}
+		return nil
+
+	case "call":
+		if len(argTypes) > 0 && argTypes[0] != nil {
+			if typ := argTypes[0].Type(); typ != nil && typ.Kind() == reflect.Func {
+				return typeOfResult(ident.Ident, typ)
+			}
+		}
+		return nil
+	}
+
+	return typeOfResult(ident.Ident, fnTyp)
+}
+
+// checkCall checks the number and types of the arguments of a call of the
+// function or method with the given name and signature, and returns the
+// FieldNodes of the arguments. If piped is true, prev is the FieldNode of the
+// value passed as the last argument from the previous command.
+func checkCall(s *scope, val reflect.Value, node parse.Node, name string, fnTyp reflect.Type, args []parse.Node, prev *FieldNode, piped bool, helper *AnalysisHelper) []*FieldNode {
+	numArgs := len(args)
+	if piped {
+		numArgs++
+	}
+	if fnTyp.IsVariadic() && numArgs < fnTyp.NumIn()-1 {
+		helper.AddDiagnostic(node, SeverityError, CodeInvalidArguments, fmt.Sprintf("invalid number of arguments for %q: expected at least %d, got %d", name, fnTyp.NumIn()-1, numArgs))
+	} else if !fnTyp.IsVariadic() && numArgs != fnTyp.NumIn() {
+		helper.AddDiagnostic(node, SeverityError, CodeInvalidArguments, fmt.Sprintf("invalid number of arguments for %q: expected %d, got %d", name, fnTyp.NumIn(), numArgs))
+	}
+
+	argTypes := make([]*FieldNode, 0, numArgs)
+	for i, arg := range args {
+		argTypes = append(argTypes, checkArg(s, val, name, i, arg, paramType(fnTyp, i), helper))
+	}
+	if piped {
+		param := paramType(fnTyp, len(args))
+		if prev != nil && !isAssignable(prev.Type(), param) {
+			helper.AddDiagnostic(node, SeverityError, CodeInvalidArguments, fmt.Sprintf("invalid argument %d for %q: piped value of type %s is not assignable to %s", len(args)+1, name, prev.Type(), param))
+		}
+		argTypes = append(argTypes, prev)
+	}
+
+	return argTypes
+}
+
+// checkArg checks the given argument against the type of the parameter it is
+// passed to and returns the FieldNode of the argument.
+func checkArg(s *scope, val reflect.Value, name string, i int, arg parse.Node, param reflect.Type, helper *AnalysisHelper) *FieldNode {
+	typ := typeOfArg(s, val, arg, helper)
+	if param == nil {
+		return typ
+	}
+
+	// constants are converted to the type of the parameter
+	// if possible, mirroring the rules of text/template
+	var ok bool
+	switch argTyp := arg.(type) {
+	case *parse.BoolNode:
+		ok = param.Kind() == reflect.Bool || param.Kind() == reflect.Interface
+	case *parse.StringNode:
+		ok = param.Kind() == reflect.String || param.Kind() == reflect.Interface
+	case *parse.NilNode:
+		switch param.Kind() {
+		case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+			ok = true
+		}
+	case *parse.NumberNode:
+		switch param.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			ok = argTyp.IsInt
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			ok = argTyp.IsUint
+		case reflect.Float32, reflect.Float64:
+			ok = argTyp.IsFloat
+		case reflect.Complex64, reflect.Complex128:
+			ok = argTyp.IsComplex
+		case reflect.Interface:
+			ok = true
+		}
+	default:
+		if typ != nil && !isAssignable(typ.Type(), param) {
+			helper.AddDiagnostic(arg, SeverityError, CodeInvalidArguments, fmt.Sprintf("invalid argument %d for %q: %s of type %s is not assignable to %s", i+1, name, arg, typ.Type(), param))
+		}
+		return typ
+	}
+
+	if !ok {
+		helper.AddDiagnostic(arg, SeverityError, CodeInvalidArguments, fmt.Sprintf("invalid argument %d for %q: %s is not assignable to %s", i+1, name, arg, param))
+	}
+
+	return typ
+}
+
+// typeOfResult returns the FieldNode of the first value returned by the given
+// function type, or nil if it cannot be inferred.
+func typeOfResult(name string, fnTyp reflect.Type) *FieldNode {
+	if fnTyp.NumOut() == 0 {
+		return nil
+	}
+
+	out := fnTyp.Out(0)
+	if out.Kind() == reflect.Interface {
+		// the dynamic type is not known until the template is executed
+		return nil
+	}
+
+	node, err := createFieldTreeFromType(name, out)
+	if err != nil {
+		return nil
+	}
+	return node
+}
+
+// typeOfIndex checks the indexes passed to the index builtin against the type
+// of the indexed item and returns the FieldNode of the value found, or nil if
+// it cannot be inferred.
+func typeOfIndex(node parse.Node, item *FieldNode, indexes []*FieldNode, helper *AnalysisHelper) *FieldNode {
+	typ := item.Type()
+	for i := 0; i < len(indexes) && typ != nil; i++ {
+		typ = indirectType(typ)
+
+		var want reflect.Type
+		switch typ.Kind() {
+		case reflect.Slice, reflect.Array, reflect.String:
+			want = reflect.TypeOf(0)
+		case reflect.Map:
+			want = typ.Key()
+		case reflect.Interface:
+			// the dynamic type is not known until the template is executed
+			return nil
+		default:
+			helper.AddDiagnostic(node, SeverityError, CodeInvalidArguments, fmt.Sprintf("can't index item of type %s", typ))
+			return nil
+		}
+
+		// integer indexes are converted to the type of the key,
+		// mirroring the indexArg and prepareArg functions of text/template
+		if idx := indexes[i]; idx != nil && idx.Type() != nil {
+			if !isAssignable(idx.Type(), want) && !(isIntLike(idx.Type()) && isIntLike(want)) {
+				helper.AddDiagnostic(node, SeverityError, CodeInvalidArguments, fmt.Sprintf("invalid argument %d for \"index\": index of type %s is not assignable to %s", i+2, idx.Type(), want))
+			}
+		}
+
+		if typ.Kind() == reflect.String {
+			typ = reflect.TypeOf(byte(0))
+		} else {
+			typ = typ.Elem()
+		}
+	}
+
+	if typ == nil || typ.Kind() == reflect.Interface {
+		return nil
+	}
+
+	elem, err := createFieldTreeFromType(item.StructField.Name+"[index]", typ)
+	if err != nil {
+		return nil
+	}
+	return elem
+}
+
+// typeOfField checks the given field, chain or variable node and returns the
+// FieldNode it refers to, or nil if the type cannot be inferred. Methods on the
+// way are called without arguments, except for the last one which is called
+// with the given arguments and, if piped is true, the value of prev.
+func typeOfField(s *scope, val reflect.Value, node parse.Node, args []parse.Node, prev *FieldNode, piped bool, helper *AnalysisHelper) *FieldNode {
+	var start, field *FieldNode
+	var path []string
+	// name and undefined are used to report a path that is not defined
+	var name, undefined string
+
+	switch nodeTyp := node.(type) {
 	case *parse.FieldNode:
-		if isVisited(helper.ctx, nodeTyp) {
+		if s.dot == nil {
 			break
 		}
+		start, path = s.dot, nodeTyp.Ident
+		name = s.path + nodeTyp.String()
+		undefined = fmt.Sprintf("field %q not defined in struct %T", name, val.Interface())
 
-		typ := prefix + nodeTyp.String()
-		field := helper.GetDefinedField(typ)
+	case *parse.VariableNode:
+		v, ok := s.lookup(nodeTyp.Ident[0])
+		if !ok {
+			helper.AddDiagnostic(nodeTyp, SeverityError, CodeUndefinedVariable, fmt.Sprintf("variable %q is not defined", nodeTyp.Ident[0]))
+			break
+		} else if v == nil || len(nodeTyp.Ident) == 1 {
+			field = v
+			break
+		}
+
+		start, path = v, nodeTyp.Ident[1:]
+		name = nodeTyp.String()
+		if nodeTyp.Ident[0] == "$" {
+			undefined = fmt.Sprintf("field %q not defined in struct %T", name, val.Interface())
+		} else {
+			undefined = fmt.Sprintf("field %q not defined in type %s", name, v.Type())
+		}
+
+	case *parse.ChainNode:
+		// the chained node is evaluated first: {{ (.User.Find 3).Name }}
+		typ := typeOfArg(s, val, nodeTyp.Node, helper)
+		if typ == nil {
+			break
+		}
+		start, path = typ, nodeTyp.Field
+		name = nodeTyp.String()
+		undefined = fmt.Sprintf("field %q not defined in type %s", name, typ.Type())
+	}
+
+	if start != nil {
+		field = start.FindPath(path)
 		if field == nil {
-			helper.AddError(node, fmt.Sprintf("field %q not defined in struct %T", typ, val.Interface()))
+			if typ := dynamicTypeOnPath(start, path); typ != nil {
+				helper.AddDiagnostic(node, SeverityWarning, CodeDynamicType, fmt.Sprintf("field %q cannot be checked: it is accessed through a value of type %s", name, typ))
+			} else {
+				helper.AddDiagnostic(node, SeverityError, CodeUndefinedField, undefined)
+			}
+		}
+	}
+
+	if field == nil || len(path) == 0 {
+		for _, arg := range args {
+			typeOfArg(s, val, arg, helper)
+		}
+		return field
+	}
+
+	cur := start
+	for i, name := range path {
+		cur = cur.FindPath(path[i : i+1])
+		last := i == len(path)-1
+
+		if cur.Method == nil {
+			if last && (len(args) > 0 || piped) {
+				helper.AddDiagnostic(node, SeverityError, CodeInvalidArguments, fmt.Sprintf("%s is not a method but has arguments", name))
+				for _, arg := range args {
+					typeOfArg(s, val, arg, helper)
+				}
+			}
+			continue
 		}
-		helper.WithContext(setVisited(helper.Context(), nodeTyp))
 
-		// TODO: can we make further assertions here about the type of the field?
+		if last {
+			checkCall(s, val, node, name, cur.Method, args, prev, piped, helper)
+		} else {
+			checkCall(s, val, node, name, cur.Method, nil, nil, false, helper)
+		}
 
-		break
+		// mirrors the goodFunc check of text/template
+		numOut := cur.Method.NumOut()
+		if numOut == 0 || numOut > 2 || (numOut == 2 && cur.Method.Out(1) != reflect.TypeOf((*error)(nil)).Elem()) {
+			helper.AddDiagnostic(node, SeverityError, CodeInvalidResults, fmt.Sprintf("method %q must return 1 value, or 2 values with the second of type error: got %d", name, numOut))
+		}
 	}
+
+	return field
+}
+
+// dynamicTypeOnPath returns the type of the first value of an empty interface
+// type on the given path starting at the given FieldNode, or nil if there is
+// none. The fields of such values are only known when the template is executed.
+func dynamicTypeOnPath(start *FieldNode, path []string) reflect.Type {
+	cur := start
+	for i := range path {
+		if isEmptyInterface(cur.Type()) {
+			return cur.Type()
+		}
+		if cur = cur.FindPath(path[i : i+1]); cur == nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// typeOfArg checks the given argument and returns its FieldNode, or nil if
+// the type cannot be inferred.
+func typeOfArg(s *scope, val reflect.Value, arg parse.Node, helper *AnalysisHelper) *FieldNode {
+	switch argTyp := arg.(type) {
+	case *parse.DotNode:
+		return s.dot
+
+	case *parse.FieldNode, *parse.ChainNode, *parse.VariableNode:
+		return typeOfField(s, val, argTyp, nil, nil, false, helper)
+
+	case *parse.PipeNode:
+		return typeOfPipe(s, val, argTyp, helper)
+
+	case *parse.IdentifierNode:
+		// a function called without arguments: {{ printf "%s" now }}
+		return typeOfCall(s, val, argTyp, argTyp, nil, nil, false, helper)
+
+	case *parse.BoolNode:
+		return createTypeNode(argTyp.String(), reflect.TypeOf(true))
+
+	case *parse.StringNode:
+		return createTypeNode(argTyp.String(), reflect.TypeOf(""))
+
+	case *parse.NumberNode:
+		// the type of number constants is guided by their syntax,
+		// mirroring the idealConstant function of text/template
+		text := argTyp.Text
+		isHexInt := len(text) > 2 && text[0] == '0' && (text[1] == 'x' || text[1] == 'X') && !strings.ContainsAny(text, "pP")
+		isRuneInt := len(text) > 0 && text[0] == '\''
+		switch {
+		case argTyp.IsComplex:
+			return createTypeNode(text, reflect.TypeOf(0i))
+		case argTyp.IsFloat && !isHexInt && !isRuneInt && strings.ContainsAny(text, ".eEpP"):
+			return createTypeNode(text, reflect.TypeOf(0.0))
+		case argTyp.IsInt:
+			return createTypeNode(text, reflect.TypeOf(0))
+		case argTyp.IsUint:
+			return createTypeNode(text, reflect.TypeOf(uint(0)))
+		case argTyp.IsFloat:
+			return createTypeNode(text, reflect.TypeOf(0.0))
+		}
+	}
+
+	return nil
 }
 
 // staticTyping enables static type checking on templateProvider parse trees by using
 // reflection on the given struct type.
 var staticTyping Analyzer = func(helper *AnalysisHelper) AnalyzerFunc {
 	return func(val reflect.Value, node parse.Node) {
-		staticTypingRecursive("", val, node, helper)
+		// the first node visited is the root of the parse tree. the whole tree is
+		// checked from there so that variables can be tracked in their scope.
+		staticTypingRecursive(newScope(helper.fieldTree), val, node, helper)
+	}
+}
+
+// layoutOutlets reports outlets that Compile cannot wire, because they are not
+// in the template of a layout or not the only command of their action.
+var layoutOutlets Analyzer = func(helper *AnalysisHelper) AnalyzerFunc {
+	// the identifiers of the outlets that are wired by Compile
+	wired := make(map[parse.Node]bool)
+
+	return func(val reflect.Value, node parse.Node) {
+		switch node := node.(type) {
+		case *parse.ActionNode:
+			if _, ok := outletName(node); !ok {
+				return
+			}
+			if !helper.IsLayout(helper.tree.Name) {
+				helper.AddDiagnostic(node, SeverityError, CodeInvalidOutlet, fmt.Sprintf("outlet used outside of a layout: %s is not the layout of any template", helper.tree.Name))
+			}
+			wired[node.Pipe.Cmds[0].Args[0]] = true
+		case *parse.IdentifierNode:
+			if node.Ident == outletFuncName && !wired[node] {
+				helper.AddDiagnostic(node, SeverityError, CodeInvalidOutlet, "outlet must be used as an action of its own: {{ outlet }} or {{ outlet \"name\" }}")
+			}
+		}
 	}
 }
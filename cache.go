@@ -0,0 +1,74 @@
+package tmpl
+
+import (
+	"container/list"
+	"sync"
+)
+
+// variantCacheSize is the number of clones of a Template kept for each set of
+// keys passed to RenderProcess.Mutate. Once it is exceeded, the clone used
+// least recently is dropped and made again the next time it is used.
+const variantCacheSize = 64
+
+// lruCache is a cache of a fixed size that drops the entry used least
+// recently when it is full. It is safe for concurrent use.
+type lruCache[V any] struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+type lruEntry[V any] struct {
+	key   string
+	value V
+}
+
+func newLRUCache[V any](size int) *lruCache[V] {
+	return &lruCache[V]{
+		size:  size,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// get returns the value cached under the given key and marks it as used.
+func (c *lruCache[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.order.MoveToFront(el)
+		return el.Value.(*lruEntry[V]).value, true
+	}
+
+	var zero V
+	return zero, false
+}
+
+// add caches the given value under the given key, unless a value is already
+// cached under it. It returns the cached value.
+func (c *lruCache[V]) add(key string, value V) V {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.order.MoveToFront(el)
+		return el.Value.(*lruEntry[V]).value
+	}
+
+	c.items[key] = c.order.PushFront(&lruEntry[V]{key: key, value: value})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry[V]).key)
+	}
+	return value
+}
+
+// len returns the number of cached values.
+func (c *lruCache[V]) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
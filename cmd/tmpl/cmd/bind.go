@@ -11,8 +11,15 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
+	"text/template/parse"
+	"time"
+	"unicode"
 
 	"github.com/spf13/cobra"
 )
@@ -20,6 +27,8 @@ import (
 var (
 	Outfile *string
 	Mode    *string
+	Gotype  *bool
+	Poll    *time.Duration
 
 	//go:embed templates/_tmpl.tmpl
 	tmplHelperTmplText string
@@ -27,6 +36,8 @@ var (
 	fileProviderTmplText string
 	//go:embed templates/textprovider.tmpl
 	textProviderTmplText string
+	//go:embed templates/targets.tmpl
+	targetsTmplText string
 )
 
 const (
@@ -38,12 +49,42 @@ const (
 	BinderTypeEmbed string = "embed"
 )
 
+// gotypeRegexp matches a GoLand gotype annotation at the start of a template
+// file, such as {{/* gotype: github.com/user/app.LoginPage */}}. The second
+// group is the annotated type.
+var gotypeRegexp = regexp.MustCompile(`^(\{\{-?\s*/\*\s*gotype:\s*)(\S+?)(\s*\*/\s*-?\}\})`)
+
 type TemplateBinding struct {
 	Args       []string
 	BinderType string
 	FileName   string
 	FilePaths  []string
 	StructType string
+
+	// TypeParams is the list of type parameter names of a generic StructType
+	// in receiver syntax, such as "[T]" or "[K, V]". It is empty if the
+	// StructType is not generic.
+	TypeParams string
+	// Dir is the directory the Pattern is relative to. It is used as
+	// the root of the fs.FS in the file binder mode.
+	Dir string
+	// Pattern is the FileName converted to a valid fs.Glob pattern
+	Pattern string
+	// Poll is the interval the template files are polled on for changes
+	// in the file binder mode. Polling is disabled if it is zero.
+	Poll time.Duration
+	// Targets are the templates that can be passed to tmpl.WithTarget
+	// when rendering the StructType, sorted by name.
+	Targets []BindingTarget
+}
+
+// BindingTarget is a template of a TemplateBinding that can be rendered
+// using tmpl.WithTarget.
+type BindingTarget struct {
+	// Field is the name of the Go field the target is generated as
+	Field string
+	// Name is the name of the template
+	Name string
 }
 
 func (b *TemplateBinding) TemplateText() string {
@@ -102,6 +143,8 @@ func init() {
 
 	Outfile = bindCmd.Flags().String("outfile", "tmpl.gen.go", "set the output go file for template bindings")
 	Mode = bindCmd.Flags().String("mode", BinderTypeFile, "set the binder mode (embed|file)")
+	Poll = bindCmd.Flags().Duration("poll", 0, "poll the bound template files for changes on the given interval in file mode, for development builds")
+	Gotype = bindCmd.Flags().Bool("gotype", false, "insert or update {{/* gotype: */}} annotations at the top of the bound template files")
 	if mode, ok := os.LookupEnv("TMPL_BIND_MODE"); Mode == nil && ok {
 		Mode = &mode
 	}
@@ -142,12 +185,19 @@ func analyzeGoFile(goFile string) []TemplateBinding {
 									panic(fmt.Sprintf("failed to glob pattern '%s': %v", pattern, err))
 								}
 
+								dir, pattern := splitPattern(filepath.Dir(goFile), s[1])
+
 								b := TemplateBinding{
 									Args:       s[2:],
 									FileName:   s[1],
 									FilePaths:  matches,
 									StructType: ts.Name.Name,
+									TypeParams: typeParams(ts),
 									BinderType: *Mode,
+									Dir:        dir,
+									Pattern:    pattern,
+									Targets:    bindingTargets(ts, matches),
+									Poll:       *Poll,
 								}
 
 								res = append(res, b)
@@ -172,8 +222,11 @@ func writeBinderFile(outfile string, packageName string, bindings []TemplateBind
 			imports["path/filepath"] = ""
 			imports["strings"] = ""
 		case BinderTypeFile:
-			imports["bytes"] = ""
 			imports["os"] = ""
+			imports["github.com/tylermmorton/tmpl"] = ""
+			if binding.Poll > 0 {
+				imports["time"] = ""
+			}
 		}
 	}
 
@@ -207,10 +260,20 @@ func writeBinderFile(outfile string, packageName string, bindings []TemplateBind
 			return fmt.Errorf("could not parse binder template: %v", err)
 		}
 
-		err = t.Execute(&b, &binding)
+		_, err = t.New("targets").Parse(targetsTmplText)
+		if err != nil {
+			return fmt.Errorf("could not parse targets template: %v", err)
+		}
+
+		err = t.ExecuteTemplate(&b, "binder", &binding)
 		if err != nil {
 			return fmt.Errorf("could not execute binder template: %v", err)
 		}
+
+		err = t.ExecuteTemplate(&b, "targets", &binding)
+		if err != nil {
+			return fmt.Errorf("could not execute targets template: %v", err)
+		}
 	}
 
 	src, err := format.Source([]byte(b.String()))
@@ -227,8 +290,169 @@ func writeBinderFile(outfile string, packageName string, bindings []TemplateBind
 	return nil
 }
 
+// typeParams returns the names of the type parameters of the given type spec
+// in receiver syntax, or an empty string if the type is not generic.
+func typeParams(ts *ast.TypeSpec) string {
+	if ts.TypeParams == nil || len(ts.TypeParams.List) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0)
+	for _, field := range ts.TypeParams.List {
+		for _, name := range field.Names {
+			names = append(names, name.Name)
+		}
+	}
+	return "[" + strings.Join(names, ", ") + "]"
+}
+
+// bindingTargets returns the targets of the given type spec: the names in the
+// tmpl struct tags of its fields and the names of the templates defined in
+// the given template files. The names are not namespaced, because the prefix
+// given by tmpl.UseNamespaces depends on where the type is nested.
+func bindingTargets(ts *ast.TypeSpec, filePaths []string) []BindingTarget {
+	names := make(map[string]bool)
+
+	if st, ok := ts.Type.(*ast.StructType); ok {
+		for _, field := range st.Fields.List {
+			if name := tagTemplateName(field); len(name) != 0 {
+				names[name] = true
+			}
+		}
+	}
+
+	for _, filePath := range filePaths {
+		byt, err := os.ReadFile(filePath)
+		if err != nil {
+			log.Printf("Unable to read template file '%s':\n\t%+v", filePath, err)
+			continue
+		}
+
+		// function calls can't be checked without the FuncMap passed
+		// to tmpl.Compile, so they are skipped
+		tree := parse.New(filePath)
+		tree.Mode = parse.SkipFuncCheck
+		treeSet := make(map[string]*parse.Tree)
+		if _, err := tree.Parse(string(byt), "", "", treeSet); err != nil {
+			log.Printf("Unable to parse template file '%s':\n\t%+v", filePath, err)
+			continue
+		}
+
+		for name := range treeSet {
+			if name != filePath {
+				names[name] = true
+			}
+		}
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	targets := make([]BindingTarget, 0, len(sorted))
+	fields := make(map[string]int)
+	for _, name := range sorted {
+		field := targetField(name)
+		if fields[field]++; fields[field] > 1 {
+			field = fmt.Sprintf("%s%d", field, fields[field])
+		}
+		targets = append(targets, BindingTarget{Field: field, Name: name})
+	}
+	return targets
+}
+
+// tagTemplateName returns the name of the template provided by the given
+// struct field according to its tmpl struct tag, or an empty string if the
+// field has no tmpl struct tag.
+func tagTemplateName(field *ast.Field) string {
+	if field.Tag == nil {
+		return ""
+	}
+
+	tag, err := strconv.Unquote(field.Tag.Value)
+	if err != nil {
+		return ""
+	}
+
+	value, ok := reflect.StructTag(tag).Lookup("tmpl")
+	if !ok {
+		return ""
+	}
+
+	name := strings.TrimSpace(strings.Split(value, ",")[0])
+	if len(name) != 0 {
+		return name
+	}
+
+	// like tmpl.Compile, fall back to the name of the field
+	if len(field.Names) != 0 {
+		return field.Names[0].Name
+	}
+	expr := field.Type
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.SelectorExpr:
+			return e.Sel.Name
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// targetField converts the name of a template into an exported Go identifier,
+// such as "nav-bar" or "form.input" into NavBar and FormInput.
+func targetField(name string) string {
+	b := strings.Builder{}
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+
+	field := b.String()
+	if len(field) == 0 || !unicode.IsUpper([]rune(field)[0]) {
+		field = "T" + field
+	}
+	return field
+}
+
+// splitPattern converts a file pattern relative to dir into a directory and a
+// pattern that is valid for use with fs.Glob, which does not allow ".." elements.
+func splitPattern(dir, pattern string) (string, string) {
+	pattern = filepath.Clean(pattern)
+	for pattern == ".." || strings.HasPrefix(pattern, ".."+string(filepath.Separator)) {
+		dir = filepath.Dir(dir)
+		pattern = strings.TrimPrefix(strings.TrimPrefix(pattern, ".."), string(filepath.Separator))
+	}
+	return dir, filepath.ToSlash(pattern)
+}
+
 func bindGoFile(goFile string, outFile string) error {
-	return writeBinderFile(outFile, filepath.Base(filepath.Dir(goFile)), analyzeGoFile(goFile))
+	bindings := analyzeGoFile(goFile)
+	if *Gotype {
+		if err := annotateBindings(filepath.Dir(goFile), bindings); err != nil {
+			return err
+		}
+	}
+
+	return writeBinderFile(outFile, filepath.Base(filepath.Dir(goFile)), bindings)
 }
 
 func bindGoPackage(dir, outFile string, recursive bool) error {
@@ -253,5 +477,83 @@ func bindGoPackage(dir, outFile string, recursive bool) error {
 		return nil
 	}
 
+	if *Gotype {
+		if err := annotateBindings(dir, bindings); err != nil {
+			return err
+		}
+	}
+
 	return writeBinderFile(filepath.Join(dir, outFile), filepath.Base(dir), bindings)
 }
+
+// annotateBindings annotates the template files of the given bindings, which
+// are declared in the Go package in dir, with the type of their StructType.
+func annotateBindings(dir string, bindings []TemplateBinding) error {
+	pkgs, err := listGoPackages([]string{dir})
+	if err != nil {
+		return err
+	}
+	if len(pkgs) != 1 {
+		return fmt.Errorf("could not resolve the import path of '%s'", dir)
+	}
+
+	annotated := make(map[string]string)
+	for _, binding := range bindings {
+		if len(binding.TypeParams) != 0 {
+			log.Printf("- skip gotype annotation of generic %s", binding.StructType)
+			continue
+		}
+
+		typ := pkgs[0].ImportPath + "." + binding.StructType
+		for _, filePath := range binding.FilePaths {
+			if other, ok := annotated[filePath]; ok {
+				if other != typ {
+					log.Printf("- skip gotype annotation of '%s': it is already bound to %s", filePath, other)
+				}
+				continue
+			}
+			annotated[filePath] = typ
+
+			if err := annotateTemplateFile(filePath, typ); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// annotateTemplateFile inserts a gotype annotation of the given type at the
+// top of the given template file, or updates the type of an existing one. The
+// annotation trims the line break following it so that the output of the
+// template is unchanged. The file is not written if it is already annotated.
+func annotateTemplateFile(filePath string, typ string) error {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return fmt.Errorf("could not read template file: %v", err)
+	}
+
+	byt, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("could not read template file: %v", err)
+	}
+
+	text := string(byt)
+	if match := gotypeRegexp.FindStringSubmatchIndex(text); match != nil {
+		if text[match[4]:match[5]] == typ {
+			return nil
+		}
+		text = text[:match[4]] + typ + text[match[5]:]
+	} else {
+		text = fmt.Sprintf("{{/* gotype: %s */ -}}\n", typ) + text
+	}
+
+	log.Printf("- annotate '%s' with gotype %s", filePath, typ)
+
+	err = os.WriteFile(filePath, []byte(text), info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("could not write template file: %v", err)
+	}
+
+	return nil
+}
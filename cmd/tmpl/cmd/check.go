@@ -0,0 +1,340 @@
+package cmd
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"github.com/tylermmorton/tmpl"
+)
+
+var (
+	//go:embed templates/check.tmpl
+	checkTmplText string
+)
+
+// checkFileName is the name of the test file that is overlaid into each
+// checked package. It never exists on disk in the package directory.
+const checkFileName = "tmpl_check_test.go"
+
+// goPackage is the subset of `go list -json` output used by the check command
+type goPackage struct {
+	Dir        string
+	ImportPath string
+	Name       string
+	GoFiles    []string
+}
+
+// checkTarget is a package containing TemplateProviders to be analyzed
+type checkTarget struct {
+	PackageName string
+	StructTypes []string
+	OutFile     string
+
+	pkg goPackage
+}
+
+// checkResult is the result of analyzing a single TemplateProvider
+type checkResult struct {
+	Provider    string
+	Diagnostics []tmpl.Diagnostic
+	Error       string
+}
+
+// checkCmd represents the check command
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Runs static analysis on all TemplateProviders in the given packages",
+	Long: `Finds all structs annotated with //tmpl:bind or implementing TemplateProvider
+in the given packages and analyzes their templates using the builtin analyzers
+and any analyzers registered via tmpl.RegisterAnalyzers.`,
+
+	Args:          cobra.MinimumNArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pkgs, err := listGoPackages(args)
+		if err != nil {
+			return err
+		}
+
+		tmp, err := os.MkdirTemp("", "tmpl-check-")
+		if err != nil {
+			return fmt.Errorf("could not create temp directory: %v", err)
+		}
+		defer os.RemoveAll(tmp)
+
+		targets := make([]*checkTarget, 0)
+		results := make([]checkResult, 0)
+		for i, pkg := range pkgs {
+			files := make([]string, 0, len(pkg.GoFiles))
+			for _, file := range pkg.GoFiles {
+				files = append(files, filepath.Join(pkg.Dir, file))
+			}
+
+			structTypes, unbound := findTemplateProviders(files)
+			for _, name := range unbound {
+				results = append(results, checkResult{
+					Provider: pkg.ImportPath + "." + name,
+					Error:    "annotated with //tmpl:bind but has no TemplateText method: run tmpl bind first",
+				})
+			}
+			if len(structTypes) == 0 {
+				continue
+			}
+
+			targets = append(targets, &checkTarget{
+				PackageName: pkg.Name,
+				StructTypes: structTypes,
+				OutFile:     filepath.Join(tmp, fmt.Sprintf("result_%d.json", i)),
+				pkg:         pkg,
+			})
+		}
+
+		if len(targets) == 0 && len(results) == 0 {
+			fmt.Fprintln(cmd.OutOrStdout(), "no template providers found")
+			return nil
+		}
+
+		checked, err := runChecks(tmp, targets)
+		if err != nil {
+			return err
+		}
+		results = append(results, checked...)
+
+		errCount := printCheckResults(cmd.OutOrStdout(), results)
+		if errCount > 0 {
+			return fmt.Errorf("found %d error(s)", errCount)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
+}
+
+// listGoPackages uses `go list` to resolve the given package patterns
+func listGoPackages(patterns []string) ([]goPackage, error) {
+	stdout := bytes.Buffer{}
+	stderr := bytes.Buffer{}
+
+	c := exec.Command("go", append([]string{"list", "-json"}, patterns...)...)
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	if err := c.Run(); err != nil {
+		return nil, fmt.Errorf("go list failed: %v\n%s", err, stderr.String())
+	}
+
+	pkgs := make([]goPackage, 0)
+	dec := json.NewDecoder(&stdout)
+	for dec.More() {
+		var pkg goPackage
+		if err := dec.Decode(&pkg); err != nil {
+			return nil, fmt.Errorf("could not decode go list output: %v", err)
+		}
+		pkgs = append(pkgs, pkg)
+	}
+
+	return pkgs, nil
+}
+
+// findTemplateProviders returns the names of all struct types declared in the
+// given files of a package that have a TemplateText method. It also returns the
+// names of the struct types annotated with //tmpl:bind that have none yet,
+// because their binder has not been generated. Other types cannot be analyzed
+// by instantiating their zero value and are skipped.
+func findTemplateProviders(goFiles []string) (providers []string, unbound []string) {
+	providers = make([]string, 0)
+	unbound = make([]string, 0)
+
+	structs := make(map[string]bool)
+	annotated := make([]string, 0)
+	methods := make(map[string]bool)
+	receivers := make([]string, 0)
+
+	for _, goFile := range goFiles {
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, goFile, nil, parser.ParseComments)
+		if err != nil {
+			continue
+		}
+
+		for _, decl := range f.Decls {
+			switch decl := decl.(type) {
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					// generic types cannot be instantiated without type arguments
+					if ts, ok := spec.(*ast.TypeSpec); ok && ts.TypeParams == nil {
+						if _, ok := ts.Type.(*ast.StructType); ok {
+							structs[ts.Name.Name] = true
+						}
+					}
+				}
+
+				if decl.Doc == nil || len(decl.Specs) == 0 {
+					continue
+				}
+				for _, comment := range decl.Doc.List {
+					if !strings.HasPrefix(comment.Text, BindPrefix) {
+						continue
+					}
+					if ts, ok := decl.Specs[0].(*ast.TypeSpec); ok {
+						annotated = append(annotated, ts.Name.Name)
+					}
+				}
+
+			case *ast.FuncDecl:
+				if decl.Name.Name != "TemplateText" || decl.Recv == nil || len(decl.Recv.List) != 1 ||
+					len(decl.Type.Params.List) != 0 || decl.Type.Results == nil || len(decl.Type.Results.List) != 1 {
+					continue
+				}
+
+				recv := decl.Recv.List[0].Type
+				if star, ok := recv.(*ast.StarExpr); ok {
+					recv = star.X
+				}
+				if ident, ok := recv.(*ast.Ident); ok {
+					methods[ident.Name] = true
+					receivers = append(receivers, ident.Name)
+				}
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range append(annotated, receivers...) {
+		if seen[name] || !structs[name] {
+			continue
+		}
+		seen[name] = true
+
+		if methods[name] {
+			providers = append(providers, name)
+		} else {
+			unbound = append(unbound, name)
+		}
+	}
+
+	return providers, unbound
+}
+
+// runChecks overlays a generated test file into each target package and runs
+// the tests, which write the analysis results of each package to a JSON file.
+// The packages are tested separately, so that a package that fails to build is
+// reported as an error without hiding the results of the other packages.
+func runChecks(tmp string, targets []*checkTarget) ([]checkResult, error) {
+	t, err := template.New("check").Parse(checkTmplText)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse check template: %v", err)
+	}
+
+	overlay := struct {
+		Replace map[string]string
+	}{
+		Replace: make(map[string]string),
+	}
+
+	for i, target := range targets {
+		b := bytes.Buffer{}
+		if err := t.Execute(&b, target); err != nil {
+			return nil, fmt.Errorf("could not execute check template: %v", err)
+		}
+
+		src, err := format.Source(b.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("could not format check file: %v", err)
+		}
+
+		file := filepath.Join(tmp, fmt.Sprintf("check_%d.go", i))
+		if err := os.WriteFile(file, src, 0644); err != nil {
+			return nil, fmt.Errorf("could not write check file: %v", err)
+		}
+
+		overlay.Replace[filepath.Join(target.pkg.Dir, checkFileName)] = file
+	}
+
+	byt, err := json.Marshal(overlay)
+	if err != nil {
+		return nil, err
+	}
+	overlayFile := filepath.Join(tmp, "overlay.json")
+	if err := os.WriteFile(overlayFile, byt, 0644); err != nil {
+		return nil, fmt.Errorf("could not write overlay file: %v", err)
+	}
+
+	results := make([]checkResult, 0)
+	for _, target := range targets {
+		c := exec.Command("go", "test", "-overlay", overlayFile, "-count=1", "-run", "^Test_tmplCheck$", target.pkg.ImportPath)
+		if out, err := c.CombinedOutput(); err != nil {
+			results = append(results, checkResult{
+				Provider: target.pkg.ImportPath,
+				Error:    fmt.Sprintf("go test failed: %v\n%s", err, strings.TrimSpace(string(out))),
+			})
+			continue
+		}
+
+		byt, err := os.ReadFile(target.OutFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read results of package %s: %v", target.pkg.ImportPath, err)
+		}
+
+		res := make([]checkResult, 0)
+		if err := json.Unmarshal(byt, &res); err != nil {
+			return nil, fmt.Errorf("could not decode results of package %s: %v", target.pkg.ImportPath, err)
+		}
+
+		for i := range res {
+			res[i].Provider = target.pkg.ImportPath + "." + res[i].Provider
+		}
+		results = append(results, res...)
+	}
+
+	return results, nil
+}
+
+// printCheckResults prints all diagnostics and returns the number of errors.
+// Nested templates are analyzed once per provider they are embedded in, so
+// duplicate diagnostics are only printed once.
+func printCheckResults(w io.Writer, results []checkResult) int {
+	errCount := 0
+	seen := make(map[tmpl.Diagnostic]bool)
+	for _, res := range results {
+		if len(res.Error) != 0 {
+			errCount++
+			fmt.Fprintf(w, "%s: error: %s\n", res.Provider, res.Error)
+			continue
+		}
+
+		for _, d := range res.Diagnostics {
+			if seen[d] {
+				continue
+			}
+			seen[d] = true
+
+			if d.Severity == tmpl.SeverityError {
+				errCount++
+			}
+
+			msg := fmt.Sprintf("%s: %s: %s", d.Location(), d.Severity, d.Message)
+			if len(d.Code) != 0 {
+				msg += fmt.Sprintf(" (%s)", d.Code)
+			}
+			fmt.Fprintln(w, msg)
+		}
+	}
+	return errCount
+}
@@ -1,9 +1,12 @@
 package tmpl
 
 import (
+	"context"
 	"fmt"
 	"html/template"
+	"log"
 	"reflect"
+	"strings"
 	"sync"
 )
 
@@ -13,6 +16,10 @@ type CompilerOptions struct {
 	analyzers []Analyzer
 	// parseOpts are the options passed to the template parser
 	parseOpts ParseOptions
+	// ctx controls the lifetime of the watcher routine spawned by Compile
+	ctx context.Context
+	// onWatchError is called when recompiling a watched template fails
+	onWatchError func(err error)
 }
 
 // CompilerOption is a function that can be used to modify the CompilerOptions
@@ -44,7 +51,63 @@ func UseParseOptions(parseOpts ParseOptions) CompilerOption {
 	}
 }
 
-func compile(tp TemplateProvider, opts ParseOptions, analyzers ...Analyzer) (*template.Template, error) {
+// UseConcreteTypes makes the analyzers trust the values of the TemplateProvider
+// passed to Compile. Interface fields that hold a non-nil value are checked
+// against the type of that value instead of the method set of the interface.
+// The values must be of the same type whenever the template is rendered.
+func UseConcreteTypes() CompilerOption {
+	return func(opts *CompilerOptions) {
+		opts.parseOpts.ConcreteTypes = true
+	}
+}
+
+// UseNamespaces prefixes the names of the templates defined in the text of each
+// nested TemplateProvider with the name of the nested template and a dot. A
+// {{ define "input" }} in the template of a field tagged `tmpl:"form"` defines
+// the template "form.input", which can be called as "input" within the text
+// that defines it. This prevents nested templates from replacing each other's
+// definitions.
+func UseNamespaces() CompilerOption {
+	return func(opts *CompilerOptions) {
+		opts.parseOpts.Namespaces = true
+	}
+}
+
+// UseContext sets the context that controls the lifetime of the watcher routine
+// spawned by Compile. When the context is cancelled the Template stops watching
+// for changes and keeps the last successfully compiled template.
+func UseContext(ctx context.Context) CompilerOption {
+	return func(opts *CompilerOptions) {
+		opts.ctx = ctx
+	}
+}
+
+// UseWatchErrorHandler sets the function that is called when a Template fails to
+// recompile after being signaled by a TemplateWatcher. By default, the error is
+// logged using the standard logger.
+func UseWatchErrorHandler(fn func(err error)) CompilerOption {
+	return func(opts *CompilerOptions) {
+		opts.onWatchError = fn
+	}
+}
+
+// compiledTemplate is the result of compiling a TemplateProvider
+type compiledTemplate struct {
+	// template is the parsed template of the TemplateProvider, including
+	// all of its nested templates
+	template *template.Template
+	// entry is the name of the template rendered by default, which is the
+	// outermost layout of the TemplateProvider if it has any
+	entry string
+	// helper holds the results of the analysis of the TemplateProvider
+	helper *AnalysisHelper
+	// targets are the sorted names of the templates that can be rendered
+	// using WithTarget
+	targets []string
+}
+
+// compile analyzes and parses the given TemplateProvider.
+func compile(tp TemplateProvider, opts ParseOptions, analyzers ...Analyzer) (*compiledTemplate, error) {
 	var (
 		err error
 		t   *template.Template
@@ -58,14 +121,21 @@ func compile(tp TemplateProvider, opts ParseOptions, analyzers ...Analyzer) (*te
 	// recursively parse all templates into a single template instance
 	// this block is responsible for constructing the template that
 	// will be rendered by the user
+	isSlot := slotFilter()
+	// aliases are the names nested TemplateProviders are parsed under a
+	// second time, after their type, when their own nested templates are
+	// visited. They are not meant to be targeted.
+	aliases := make(map[string]bool)
 	err = recurseFieldsImplementing[TemplateProvider](tp, func(tp TemplateProvider, field reflect.StructField) error {
 		var templateText string
 
-		templateName, ok := field.Tag.Lookup("tmpl")
-		if !ok {
-			templateName = field.Name
+		// slots are parsed last, so that they replace the blocks they override
+		if isSlot(tp, field) {
+			return nil
 		}
 
+		templateName := templateNameOf(field, field.Name)
+
 		if t == nil {
 			// if t is nil, that means this is the recursive entrypoint
 			// and some construction needs to happen
@@ -77,10 +147,20 @@ func compile(tp TemplateProvider, opts ParseOptions, analyzers ...Analyzer) (*te
 			// Analyzers can provide functions to be used in templates
 			t = t.Funcs(helper.FuncMap())
 		} else {
-			// if this is a nested template wrap its text in a {{ define }}
-			// statement, so it may be referenced by the "parent" template
-			// ex: {{define %q -}}\n%s{{end}}
-			templateText = fmt.Sprintf("%[1]sdefine %[3]q -%[2]s\n%[4]s%[1]send%[2]s\n", opts.LeftDelim, opts.RightDelim, templateName, tp.TemplateText())
+			// if this is a nested template parse it as a template associated
+			// with t, so it may be referenced by the "parent" template. Unlike
+			// a {{ define }} statement, this allows it to define templates
+			if field.Type == nil {
+				aliases[templateName] = true
+			}
+
+			text := nestedTemplateText(tp.TemplateText())
+			if opts.Namespaces {
+				return parseNamespaced(t, templateName, text, opts, helper.FuncMap())
+			}
+
+			_, err = t.New(templateName).Parse(text)
+			return err
 		}
 
 		t, err = t.Parse(templateText)
@@ -94,7 +174,59 @@ func compile(tp TemplateProvider, opts ParseOptions, analyzers ...Analyzer) (*te
 		return nil, fmt.Errorf("failed to compile template: %+v", err)
 	}
 
-	return t, nil
+	slots, err := collectSlots(tp)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range slots {
+		_, err = t.New(s.name).Parse(slotText(s, opts))
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile slot %q: %+v", s.name, err)
+		}
+	}
+
+	// wire the template into the outlets of its layouts, so that rendering
+	// the outermost layout renders the whole page
+	outlets, entry, err := resolveLayouts(tp, t.Name())
+	if err != nil {
+		return nil, err
+	}
+	wireOutlets(t, outlets)
+	if len(entry) == 0 {
+		entry = t.Name()
+	}
+
+	targets := make([]string, 0)
+	for _, name := range templateNames(t) {
+		if !isAlias(name, aliases, opts) {
+			targets = append(targets, name)
+		}
+	}
+
+	return &compiledTemplate{template: t, entry: entry, helper: helper, targets: targets}, nil
+}
+
+// isAlias reports whether the template with the given name is one of the given
+// aliases, or a template defined by an alias when namespacing is enabled.
+func isAlias(name string, aliases map[string]bool, opts ParseOptions) bool {
+	if aliases[name] {
+		return true
+	}
+	if opts.Namespaces {
+		for alias := range aliases {
+			if strings.HasPrefix(name, alias+namespaceSeparator) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nestedTemplateText trims the leading whitespace of the text of a nested
+// template, as nested templates have always been parsed as {{ define -}}
+// blocks that trim it.
+func nestedTemplateText(text string) string {
+	return strings.TrimLeft(text, " \t\r\n")
 }
 
 // Compile takes the given TemplateProvider, parses the templateProvider text and then
@@ -103,14 +235,20 @@ func compile(tp TemplateProvider, opts ParseOptions, analyzers ...Analyzer) (*te
 // Compile also spawns a watcher routine. If the given TemplateProvider or any
 // nested templates within implement TemplateWatcher, they can send signals over
 // the given channel when it is time for the templateProvider to be recompiled.
+// The watcher routine runs until the context given by UseContext is cancelled.
+// If recompilation fails, the previously compiled template continues to be used.
 func Compile[T TemplateProvider](tp T, opts ...CompilerOption) (Template[T], error) {
 	var (
 		c = &CompilerOptions{
-			analyzers: builtinAnalyzers,
+			analyzers: DefaultAnalyzers(),
 			parseOpts: ParseOptions{
 				LeftDelim:  "{{",
 				RightDelim: "}}",
 			},
+			ctx: context.Background(),
+			onWatchError: func(err error) {
+				log.Printf("tmpl: failed to recompile template: %+v", err)
+			},
 		}
 	)
 
@@ -123,20 +261,12 @@ func Compile[T TemplateProvider](tp T, opts ...CompilerOption) (Template[T], err
 	}
 
 	doCompile := func() (err error) {
-		var (
-			t *template.Template
-		)
-
-		t, err = compile(tp, c.parseOpts, c.analyzers...)
+		compiled, err := compile(tp, c.parseOpts, c.analyzers...)
 		if err != nil {
 			return
 		}
 
-		m.mu.Lock()
-		m.template = t
-		m.mu.Unlock()
-
-		return
+		return m.setTemplate(compiled)
 	}
 
 	err := doCompile()
@@ -144,9 +274,94 @@ func Compile[T TemplateProvider](tp T, opts ...CompilerOption) (Template[T], err
 		return nil, err
 	}
 
+	// collect the signal channels of all TemplateWatchers in the tree. Each
+	// nested TemplateProvider is visited again as the root of its own nested
+	// templates, which must not watch it twice.
+	signals := make([]<-chan struct{}, 0)
+	unwatch := make([]func(), 0)
+	isRoot := true
+	err = recurseFieldsImplementing[TemplateProvider](tp, func(tp TemplateProvider, field reflect.StructField) error {
+		if field.Type == nil && !isRoot {
+			return nil
+		}
+		isRoot = false
+
+		if tw, ok := tp.(TemplateWatcher); ok && !promotesWatch(tp) {
+			if ch := tw.Watch(); ch != nil {
+				signals = append(signals, ch)
+				if tu, ok := tp.(TemplateUnwatcher); ok {
+					unwatch = append(unwatch, func() { tu.Unwatch(ch) })
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(signals) > 0 {
+		go func() {
+			watch(c.ctx, signals, func() {
+				if err := recompile(doCompile); err != nil && c.onWatchError != nil {
+					c.onWatchError(err)
+				}
+			})
+
+			// release the channels, so that watchers can stop
+			// their routines once nobody listens to them
+			for _, fn := range unwatch {
+				fn()
+			}
+		}()
+	}
+
 	return m, nil
 }
 
+// promotesWatch reports whether the Watch method of the given TemplateProvider
+// is promoted from an embedded TemplateProvider. The embedded TemplateProvider
+// is watched on its own, so the method is not called twice.
+func promotesWatch(tp TemplateProvider) bool {
+	typ := indirectType(reflect.TypeOf(tp))
+	if typ.Kind() != reflect.Struct {
+		return false
+	}
+
+	provider := reflect.TypeOf((*TemplateProvider)(nil)).Elem()
+	watcher := reflect.TypeOf((*TemplateWatcher)(nil)).Elem()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.Anonymous {
+			continue
+		}
+
+		ptr := reflect.PointerTo(indirectType(field.Type))
+		if ptr.Implements(provider) && ptr.Implements(watcher) {
+			return true
+		}
+	}
+	return false
+}
+
+// recompile calls the given compile function of a watched template. A
+// TemplateProvider may panic in TemplateText, such as a FileProvider whose
+// files have been removed. The panic is returned as an error, so that the
+// previously compiled template continues to be used.
+func recompile(compile func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = fmt.Errorf("recovered panic during recompilation: %w", e)
+			} else {
+				err = fmt.Errorf("recovered panic during recompilation: %v", r)
+			}
+		}
+	}()
+
+	return compile()
+}
+
 func MustCompile[T TemplateProvider](p T, opts ...CompilerOption) Template[T] {
 	tmpl, err := Compile(p, opts...)
 	if err != nil {
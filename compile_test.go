@@ -2,9 +2,12 @@ package tmpl
 
 import (
 	"bytes"
+	"context"
 	_ "embed"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	. "github.com/tylermmorton/tmpl/testdata"
 )
@@ -29,11 +32,21 @@ func (*TestTemplate) TemplateText() string {
 	return testTemplateText
 }
 
+var testFuncs = FuncMap{
+	"formatDate": func(layout string, t time.Time) string {
+		return t.Format(layout)
+	},
+	"nested": func() DefinedField {
+		return DefinedField{DefField: "Hello World"}
+	},
+}
+
 // Test_Compile tests the compiler's ability to compile and render templates.
 // It's like a package level integration test at this point
 func Test_Compile(t *testing.T) {
 	testCases := map[string]struct {
 		templateProvider TemplateProvider
+		compilerOptions  []CompilerOption
 		renderOptions    []RenderOption
 
 		expectRenderOutput []string
@@ -202,6 +215,95 @@ func Test_Compile(t *testing.T) {
 			},
 			expectRenderOutput: []string{"<title>Hi</title>\\n<span>Hello World</span>"},
 		},
+		"Renders pages inside of their nested layouts": {
+			templateProvider: &LayoutPage{
+				SectionLayout: SectionLayout{
+					RootLayout: RootLayout{Title: "Docs"},
+					Section:    "Guides",
+				},
+				Content: "Hello World",
+			},
+			expectRenderOutput: []string{"<html><head><title>Docs</title></head><body><main><h1>Guides</h1><p>Hello World</p></main><aside><nav>Guides</nav></aside></body></html>"},
+		},
+		"Renders a page without its layouts when targeted": {
+			templateProvider: &LayoutPage{Content: "Hello World"},
+			renderOptions: []RenderOption{
+				WithTarget("*testdata.LayoutPage"),
+			},
+			expectRenderOutput: []string{"<p>Hello World</p>"},
+		},
+		"Renders a layout with the page in its outlet when targeted": {
+			templateProvider: &LayoutPage{
+				SectionLayout: SectionLayout{Section: "Guides"},
+				Content:       "Hello World",
+			},
+			renderOptions: []RenderOption{
+				WithTarget("section"),
+			},
+			expectRenderOutput: []string{"<main><h1>Guides</h1><p>Hello World</p></main><aside><nav>Guides</nav></aside>"},
+		},
+		"Fails to compile outlets outside of layouts": {
+			templateProvider:    &OutletOutsideLayout{},
+			expectCompileErrMsg: "testdata.OutletOutsideLayout:1:7: outlet used outside of a layout: testdata.OutletOutsideLayout is not the layout of any template",
+		},
+		"Fails to compile outlets that are not an action of their own": {
+			templateProvider:    &PipedOutletPage{},
+			expectCompileErrMsg: "piped:1:10: outlet must be used as an action of its own: {{ outlet }} or {{ outlet \"name\" }}",
+		},
+		"Fails to compile layouts that are not embedded": {
+			templateProvider:    &NamedLayoutField{},
+			expectCompileErrMsg: "layout Layout of testdata.NamedLayoutField must be an embedded field",
+		},
+		"Renders the default content of blocks that are not overridden": {
+			templateProvider:   &DefaultSlotPage{SlotLayout: SlotLayout{Title: "Home"}, Name: "home"},
+			expectRenderOutput: []string{`<head><title>Home</title><script src="/app.js"></script></head><body><p>home</p></body>`},
+		},
+		"Overrides blocks with slots declared by struct tags": {
+			templateProvider:   &TagSlotPage{SlotLayout: SlotLayout{Title: "Login"}, Name: "login"},
+			expectRenderOutput: []string{`<head><title>Login</title><script src="/login.js"></script></head><body><p>login</p></body>`},
+		},
+		"Overrides blocks with slots returned by TemplateSlots": {
+			templateProvider:   &MethodSlotPage{SlotLayout: SlotLayout{Title: "Login"}, Name: "login"},
+			expectRenderOutput: []string{`<head><title>Login</title><meta name="page" content="login"></head><body><p>login</p></body>`},
+		},
+		"Overrides blocks with empty slots": {
+			templateProvider:   &EmptySlotPage{SlotLayout: SlotLayout{Title: "Empty"}},
+			expectRenderOutput: []string{`<head><title>Empty</title></head><body><p></p></body>`},
+		},
+		"Fails to compile slots that override undeclared blocks": {
+			templateProvider:    &UndeclaredSlotPage{},
+			expectCompileErrMsg: `styles:1:1: slot "styles" overrides a block that is not declared by any template: declare it with {{ block "styles" . }}`,
+		},
+		"Fails to compile slots with undefined fields": {
+			templateProvider:    &SlotWithTypo{},
+			expectCompileErrMsg: `scripts:1:19: field ".Nmae" not defined in struct testdata.SlotWithTypo`,
+		},
+		"Fails to compile nested templates that define the same template": {
+			templateProvider:    &DuplicateDefines{},
+			expectCompileErrMsg: `signup:1:20: template "form" is defined by both testdata.LoginForm and testdata.SignupForm: first defined at login:1:20`,
+		},
+		"Fails to compile nested templates with the same name": {
+			templateProvider:    &DuplicateTags{},
+			compilerOptions:     []CompilerOption{UseNamespaces()},
+			expectCompileErrMsg: `account:1:1: template "account" is defined by both testdata.LoginForm and testdata.SignupForm: first defined at account:1:1`,
+		},
+		"Prefixes the templates defined by nested templates with their name": {
+			templateProvider: &DuplicateDefines{
+				Login:  LoginForm{User: "user"},
+				Signup: SignupForm{Email: "email"},
+			},
+			compilerOptions:    []CompilerOption{UseNamespaces()},
+			expectRenderOutput: []string{"<form>user</form><form>email</form>"},
+		},
+		"Trims the leading whitespace of nested templates": {
+			templateProvider:   &IndentedHeadPage{IndentedHead: IndentedHead{Title: "x"}},
+			expectRenderOutput: []string{"<html><title>x</title>\n</html>"},
+		},
+		"Trims the leading whitespace of namespaced nested templates": {
+			templateProvider:   &IndentedHeadPage{IndentedHead: IndentedHead{Title: "x"}},
+			compilerOptions:    []CompilerOption{UseNamespaces()},
+			expectRenderOutput: []string{"<html><title>x</title>\n</html>"},
+		},
 		"Supports usage of $ dot reference within range scopes": {
 			templateProvider: &DollarSignWithinRange{
 				DefList: []string{"1", "2"},
@@ -216,6 +318,69 @@ func Test_Compile(t *testing.T) {
 			},
 			expectRenderOutput: []string{"PASS", "FAIL"},
 		},
+		"Supports usage of {{ $x := .Field }} variable declarations": {
+			templateProvider:   &DeclaredVariable{Nested: DefinedField{DefField: "Hello World"}},
+			expectRenderOutput: []string{"Hello World"},
+		},
+		"Supports usage of {{ $x = .Field }} variable assignments": {
+			templateProvider: &AssignedVariable{
+				First:  DefinedField{DefField: "Hello"},
+				Second: DefinedField{DefField: "World"},
+			},
+			expectRenderOutput: []string{"World"},
+		},
+		"Supports usage of variables declared within {{ if }} scopes": {
+			templateProvider:   &VariableWithinIf{DefIf: true, Nested: DefinedField{DefField: "Hello World"}},
+			expectRenderOutput: []string{"Hello World"},
+		},
+		"Supports usage of {{ range $i, $v := .List }} assignments": {
+			templateProvider:   &RangeWithAssignments{List: []NamedStruct{{DefField: "Hello"}, {DefField: "World"}}},
+			expectRenderOutput: []string{"0:Hello 1:World"},
+		},
+		"Supports usage of {{ range $k, $v := .Map }} assignments over maps": {
+			templateProvider:   &RangeOverMap{Map: map[string]*NamedStruct{"a": {DefField: "Hello"}, "b": {DefField: "World"}}},
+			expectRenderOutput: []string{"a=Hello b=World"},
+		},
+		"Supports usage of {{ range $v := .Chan }} assignments over channels": {
+			templateProvider: func() TemplateProvider {
+				ch := make(chan NamedStruct, 2)
+				ch <- NamedStruct{DefField: "Hello"}
+				ch <- NamedStruct{DefField: "World"}
+				close(ch)
+				return &RangeOverChan{Chan: ch}
+			}(),
+			expectRenderOutput: []string{"HelloWorld"},
+		},
+		"Supports usage of {{ range $i := .Int }} assignments over integers": {
+			templateProvider:   &RangeOverInt{Count: 3},
+			expectRenderOutput: []string{"012"},
+		},
+		"Supports usage of {{ with }} statements": {
+			templateProvider:   &DefinedWith{Nested: &NamedStruct{DefField: "Hello World"}},
+			expectRenderOutput: []string{"Hello World"},
+		},
+		"Supports usage of the outer dot in {{ with }} {{ else }} branches": {
+			templateProvider:   &DefinedWith{Message: "Hello World"},
+			expectRenderOutput: []string{"Hello World"},
+		},
+		"Supports usage of {{ with }} {{ else with }} statements": {
+			templateProvider:   &DefinedElseWith{Second: &DefinedField{DefField: "Hello World"}},
+			expectRenderOutput: []string{"Hello World"},
+		},
+		"Supports usage of functions in pipelines": {
+			templateProvider:   &PipedFuncCall{Date: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)},
+			compilerOptions:    []CompilerOption{UseFuncs(testFuncs)},
+			expectRenderOutput: []string{"2023"},
+		},
+		"Supports usage of fields of function results": {
+			templateProvider:   &FuncCallResult{},
+			compilerOptions:    []CompilerOption{UseFuncs(testFuncs)},
+			expectRenderOutput: []string{"Hello World Hello World!"},
+		},
+		"Supports usage of comparison builtins with compatible types": {
+			templateProvider:   &CompatibleComparisons{Count: 1, Size: 1, Price: 1, Status: "ok", Any: 2},
+			expectRenderOutput: []string{"ABCD"},
+		},
 
 		// these are test cases for the compiler's built-in analyzers
 		"Catches usage of {{ template }} statements containing undefined template names": {
@@ -230,8 +395,13 @@ func Test_Compile(t *testing.T) {
 		},
 		"Catches usage of {{ if }} statements containing non-bool types": {
 			templateProvider:    &AnyTypeIf{DefIf: 0},
+			compilerOptions:     []CompilerOption{UseConcreteTypes()},
 			expectCompileErrMsg: "field \".DefIf\" is not type bool: got int",
 		},
+		"Supports usage of {{ if }} statements containing fields of type any": {
+			templateProvider:   &AnyTypeIf{DefIf: true},
+			expectRenderOutput: []string{""},
+		},
 		"Catches usage of {{ if }} statements containing undefined fields": {
 			templateProvider:    &UndefinedIf{},
 			expectCompileErrMsg: "field \".UndIf\" not defined",
@@ -248,11 +418,169 @@ func Test_Compile(t *testing.T) {
 			templateProvider:    &UndefinedNestedField{Nested: UndefinedField{}},
 			expectCompileErrMsg: "field \".Nested.UndField\" not defined",
 		},
+		"Catches usage of undefined fields on variables": {
+			templateProvider:    &UndefinedVariableField{},
+			expectCompileErrMsg: "field \"$x.UndField\" not defined in type testdata.DefinedField",
+		},
+		"Fails to parse usage of variables outside of their scope": {
+			templateProvider:    &UndeclaredVariable{},
+			expectCompileErrMsg: "undefined variable \"$x\"",
+		},
+		"Catches usage of undefined fields on {{ range }} variables": {
+			templateProvider:    &RangeUndefinedElemField{},
+			expectCompileErrMsg: "field \"$v.UndField\" not defined in type testdata.NamedStruct",
+		},
+		"Catches usage of {{ range }} over channels with two variables": {
+			templateProvider:    &RangeOverChanWithKey{},
+			expectCompileErrMsg: "with more than one variable",
+		},
+		"Catches usage of {{ range }} over types that cannot be iterated": {
+			templateProvider:    &RangeOverString{},
+			expectCompileErrMsg: "range can't iterate over \".DefStr\" of type string",
+		},
+		"Catches usage of undefined fields within {{ with }} bodies": {
+			templateProvider:    &UndefinedWithField{},
+			expectCompileErrMsg: "field \".Nested.Message\" not defined",
+		},
+		"Catches usage of undefined functions": {
+			templateProvider:    &UndefinedFuncCall{},
+			compilerOptions:     []CompilerOption{UseFuncs(testFuncs)},
+			expectCompileErrMsg: "function \"undefinedFunc\" not defined",
+		},
+		"Catches function calls with an invalid number of arguments": {
+			templateProvider:    &FuncCallArgCount{},
+			compilerOptions:     []CompilerOption{UseFuncs(testFuncs)},
+			expectCompileErrMsg: "invalid number of arguments for \"formatDate\": expected 2, got 1",
+		},
+		"Catches function calls with invalid argument types": {
+			templateProvider:    &FuncCallArgType{},
+			compilerOptions:     []CompilerOption{UseFuncs(testFuncs)},
+			expectCompileErrMsg: "invalid argument 2 for \"formatDate\": .Name of type string is not assignable to time.Time",
+		},
+		"Catches usage of undefined fields of function results": {
+			templateProvider:    &FuncCallResultField{},
+			compilerOptions:     []CompilerOption{UseFuncs(testFuncs)},
+			expectCompileErrMsg: "field \"(nested).UndField\" not defined",
+		},
+		"Supports calling methods with arguments": {
+			templateProvider:   &MethodCalls{User: User{Name: "Bob"}, Status: "active"},
+			expectRenderOutput: []string{"Hi Bob!Hi Bob! B user3 Hey Bob! ACTIVE 1"},
+		},
+		"Catches calls of methods with the wrong number of arguments": {
+			templateProvider:    &MethodCallArgCount{},
+			expectCompileErrMsg: "invalid number of arguments for \"Greet\": expected 2, got 1",
+		},
+		"Catches calls of methods with arguments of the wrong type": {
+			templateProvider:    &MethodCallArgType{},
+			expectCompileErrMsg: "invalid argument 1 for \"Greet\": 1 is not assignable to string",
+		},
+		"Catches usage of undefined fields of method results": {
+			templateProvider:    &MethodCallResultField{},
+			expectCompileErrMsg: "field \"(.User.Find 3).Nmae\" not defined in type *testdata.User",
+		},
+		"Catches calls of methods with invalid results": {
+			templateProvider:    &MethodCallInvalidResults{},
+			expectCompileErrMsg: "method \"Split\" must return 1 value, or 2 values with the second of type error: got 2",
+		},
+		"Catches usage of fields with arguments": {
+			templateProvider:    &FieldWithArguments{},
+			expectCompileErrMsg: "Name is not a method but has arguments",
+		},
+		"Supports map keys as field names and map values": {
+			templateProvider: &MapFields{
+				Labels: map[string]string{"env": "prod"},
+				ByID:   map[int]Label{1: {Value: "one"}},
+				Named:  map[string]*Label{"home": {Value: "/"}},
+			},
+			expectRenderOutput: []string{"prod one / 1=one"},
+		},
+		"Catches usage of undefined fields of map values": {
+			templateProvider:    &UndefinedMapValueField{},
+			expectCompileErrMsg: "field \".Named.home.Vaule\" not defined",
+		},
+		"Catches usage of map keys as field names of maps without string keys": {
+			templateProvider:    &NonStringMapKeyPath{},
+			expectCompileErrMsg: "field \".ByID.one\" not defined",
+		},
+		"Catches usage of index with keys of the wrong type": {
+			templateProvider:    &InvalidMapIndex{},
+			expectCompileErrMsg: "invalid argument 2 for \"index\": index of type string is not assignable to int",
+		},
+		"Supports instantiated generic structs": {
+			templateProvider:   &GenericPage[Item]{Title: "Items", Items: []Item{{Name: "a"}, {Name: "b"}}, First: Item{Name: "a"}},
+			expectRenderOutput: []string{"Items a b a"},
+		},
+		"Catches usage of undefined fields of type parameters": {
+			templateProvider:    &UndefinedGenericField[Item]{},
+			expectCompileErrMsg: "field \".Items.Nmae\" not defined",
+		},
+		"Supports calling the declared methods of interface fields": {
+			templateProvider:   &InterfaceField{Shape: Square{Side: 2}},
+			expectRenderOutput: []string{"4"},
+		},
+		"Catches usage of methods not declared by interface fields": {
+			templateProvider:    &UndeclaredInterfaceMethod{Shape: Square{Side: 2}},
+			expectCompileErrMsg: "field \".Shape.Side\" not defined",
+		},
+		"Supports usage of fields of concrete interface values": {
+			templateProvider:   &UndeclaredInterfaceMethod{Shape: Square{Side: 2}},
+			compilerOptions:    []CompilerOption{UseConcreteTypes()},
+			expectRenderOutput: []string{"2"},
+		},
+		"Supports usage of fields of values of type any": {
+			templateProvider:   &AnyField{Any: Item{Name: "any"}},
+			expectRenderOutput: []string{"any"},
+		},
+		"Catches usage of undefined fields of concrete values of type any": {
+			templateProvider:    &AnyField{Any: Square{Side: 2}},
+			compilerOptions:     []CompilerOption{UseConcreteTypes()},
+			expectCompileErrMsg: "field \".Any.Name\" not defined",
+		},
+		"Catches usage of {{ template }} statements with mismatched data types": {
+			templateProvider:    &MismatchedTemplateData{},
+			expectCompileErrMsg: "template \"head\" expects data of type testdata.Head: got testdata.Footer",
+		},
+		"Catches usage of undefined fields in nested templates": {
+			templateProvider:    &NestedTemplateWithTypo{},
+			expectCompileErrMsg: "head:1:11: field \".Titel\" not defined in struct testdata.HeadWithTypo",
+		},
+		"Catches usage of undefined fields in nested templates that are not invoked": {
+			templateProvider:    &UninvokedTemplateWithTypo{},
+			expectCompileErrMsg: "head:1:11: field \".Titel\" not defined in struct *testdata.HeadWithTypo",
+		},
+		"Catches usage of undefined fields in defined templates": {
+			templateProvider:    &DefineWithTypo{},
+			expectCompileErrMsg: "field \".Acton\" not defined in struct testdata.Form",
+		},
+		"Catches usage of undefined functions in defined templates that are not invoked": {
+			templateProvider:    &UninvokedDefine{},
+			expectCompileErrMsg: "testdata.UninvokedDefine:1:25: function \"undefinedFunc\" not defined",
+		},
+		"Catches usage of comparison builtins with incompatible types": {
+			templateProvider:    &IncompatibleComparison{},
+			expectCompileErrMsg: "incompatible types for \"eq\": int and string",
+		},
+		"Catches usage of ordered comparison builtins with invalid types": {
+			templateProvider:    &InvalidOrderedComparison{},
+			expectCompileErrMsg: "invalid type for \"lt\": bool",
+		},
+		"Catches usage of comparison builtins with non-comparable types": {
+			templateProvider:    &NonComparableComparison{},
+			expectCompileErrMsg: "non-comparable type for \"eq\": []string",
+		},
+		"Reports the line and column of analyzer errors": {
+			templateProvider:    &UndefinedNestedField{Nested: UndefinedField{}},
+			expectCompileErrMsg: "testdata.UndefinedNestedField:1:11: field \".Nested.UndField\" not defined",
+		},
+		"Reports the source file of analyzer errors": {
+			templateProvider:    &SourcedUndefinedField{},
+			expectCompileErrMsg: "testdata/undefined.tmpl.html:2:6: field \".UndField\" not defined",
+		},
 	}
 
 	for name, tc := range testCases {
 		t.Run(name, func(t *testing.T) {
-			tmpl, err := Compile(tc.templateProvider)
+			tmpl, err := Compile(tc.templateProvider, tc.compilerOptions...)
 			if err != nil {
 				if len(tc.expectCompileErrMsg) == 0 {
 					t.Fatal(err)
@@ -283,3 +611,141 @@ func Test_Compile(t *testing.T) {
 		})
 	}
 }
+
+type watchedTemplate struct {
+	Text string
+}
+
+var (
+	watchedTemplateText   atomic.Value
+	watchedTemplateSignal = make(chan struct{})
+)
+
+func (*watchedTemplate) TemplateText() string {
+	return watchedTemplateText.Load().(string)
+}
+
+func (*watchedTemplate) Watch() <-chan struct{} {
+	return watchedTemplateSignal
+}
+
+// Test_Watch tests that templates implementing TemplateWatcher are recompiled
+// when they send a signal over their watch channel.
+func Test_Watch(t *testing.T) {
+	watchedTemplateText.Store("<p>{{ .Text }}</p>")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tmpl, err := Compile(&watchedTemplate{}, UseContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := tmpl.RenderToString(&watchedTemplate{Text: "Hello World"})
+	if err != nil {
+		t.Fatal(err)
+	} else if res != "<p>Hello World</p>" {
+		t.Fatalf("expected render output to be %q, got %q", "<p>Hello World</p>", res)
+	}
+
+	watchedTemplateText.Store("<span>{{ .Text }}</span>")
+	watchedTemplateSignal <- struct{}{}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		res, err = tmpl.RenderToString(&watchedTemplate{Text: "Hello World"})
+		if err != nil {
+			t.Fatal(err)
+		} else if res == "<span>Hello World</span>" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("expected template to be recompiled, got %q", res)
+}
+
+// Test_Targets tests that Compile lists the names of all templates that can be
+// rendered using WithTarget.
+func Test_Targets(t *testing.T) {
+	tmpl, err := Compile(&LayoutPage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"*testdata.LayoutPage", "root", "section", "sidebar"}
+	if got := tmpl.Targets(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected targets to be %q, got %q", want, got)
+	}
+
+	for _, target := range want {
+		if _, err := tmpl.RenderToString(&LayoutPage{}, WithTarget(target)); err != nil {
+			t.Errorf("expected target %q to render, got %v", target, err)
+		}
+	}
+
+	namespaced, err := Compile(&DuplicateDefines{}, UseNamespaces())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = []string{"*testdata.DuplicateDefines", "login", "login.form", "signup", "signup.form"}
+	if got := namespaced.Targets(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected namespaced targets to be %q, got %q", want, got)
+	}
+}
+
+var countedWatches atomic.Int32
+
+type countedWatcher struct{}
+
+func (*countedWatcher) TemplateText() string {
+	return "<title></title>"
+}
+
+func (*countedWatcher) Watch() <-chan struct{} {
+	countedWatches.Add(1)
+	return make(chan struct{})
+}
+
+type nestedWatcherPage struct {
+	Head countedWatcher `tmpl:"head"`
+}
+
+func (*nestedWatcherPage) TemplateText() string {
+	return `{{ template "head" .Head }}`
+}
+
+type embeddedWatcherPage struct {
+	countedWatcher `tmpl:"head"`
+}
+
+func (*embeddedWatcherPage) TemplateText() string {
+	return `{{ template "head" . }}`
+}
+
+// Test_WatchOnce tests that each nested TemplateWatcher is watched once, even
+// if its Watch method is promoted to the struct embedding it.
+func Test_WatchOnce(t *testing.T) {
+	testCases := map[string]TemplateProvider{
+		"Watches nested templates once":   &nestedWatcherPage{},
+		"Watches embedded templates once": &embeddedWatcherPage{},
+	}
+
+	for name, tp := range testCases {
+		t.Run(name, func(t *testing.T) {
+			countedWatches.Store(0)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if _, err := Compile(tp, UseContext(ctx)); err != nil {
+				t.Fatal(err)
+			}
+			if got := countedWatches.Load(); got != 1 {
+				t.Fatalf("expected Watch to be called once, got %d", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package tmpl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template/parse"
+)
+
+// Severity is the severity of a Diagnostic.
+type Severity int
+
+const (
+	// SeverityError is used for problems that prevent a template from compiling.
+	SeverityError Severity = iota
+	// SeverityWarning is used for problems that do not prevent a template
+	// from compiling but are likely to be mistakes.
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// Diagnostic describes a problem found in a template during analysis.
+type Diagnostic struct {
+	// Severity is the severity of the problem.
+	Severity Severity
+	// Code is the ID of the rule that reported the problem, if any.
+	Code string
+	// Template is the name of the template the problem was found in.
+	Template string
+	// File is the path of the file the template text was loaded from. It is
+	// only set if the TemplateProvider implements TemplateSourceProvider.
+	File string
+	// Line is the 1-based line number of the offending node.
+	Line int
+	// Column is the 1-based byte column of the offending node.
+	Column int
+	// Message describes the problem.
+	Message string
+	// Node is the text of the offending parse.Node.
+	Node string
+}
+
+// Location formats the location of the Diagnostic as file:line:col. If the
+// file is not known the template name is used instead.
+func (d Diagnostic) Location() string {
+	loc := d.File
+	if len(loc) == 0 {
+		loc = d.Template
+	}
+	return fmt.Sprintf("%s:%d:%d", loc, d.Line, d.Column)
+}
+
+// String formats the Diagnostic as file:line:col: message.
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s", d.Location(), d.Message)
+}
+
+// newDiagnostic creates a Diagnostic for the given node. The tree is used as a
+// fallback to find the node's location if the node does not belong to a tree.
+func newDiagnostic(tree *parse.Tree, node parse.Node, sources map[string]string, severity Severity, code, msg string) Diagnostic {
+	d := Diagnostic{
+		Severity: severity,
+		Code:     code,
+		Message:  msg,
+	}
+
+	if tree == nil {
+		return d
+	}
+
+	// location is formatted as template:line:col, where col is 0-based
+	location, context := tree.ErrorContext(node)
+	d.Node = context
+	if i := strings.LastIndex(location, ":"); i != -1 {
+		d.Column, _ = strconv.Atoi(location[i+1:])
+		d.Column++
+		location = location[:i]
+	}
+	if i := strings.LastIndex(location, ":"); i != -1 {
+		d.Line, _ = strconv.Atoi(location[i+1:])
+		location = location[:i]
+	}
+	d.Template = location
+	d.File = sources[location]
+
+	return d
+}
+
+// AnalysisError is returned by Analyze when any errors were reported during
+// analysis. It contains all Diagnostics reported, including warnings.
+type AnalysisError struct {
+	Diagnostics []Diagnostic
+}
+
+// Error formats all diagnostics with SeverityError, one per line.
+func (e *AnalysisError) Error() string {
+	lines := make([]string, 0, len(e.Diagnostics))
+	for _, d := range e.Diagnostics {
+		if d.Severity == SeverityError {
+			lines = append(lines, d.String())
+		}
+	}
+	return strings.Join(lines, "\n")
+}
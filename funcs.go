@@ -0,0 +1,176 @@
+package tmpl
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// builtinFuncs describes the signatures of the functions predefined by the
+// text/template package. The builtins accept reflect.Value arguments, which
+// are described here as interface{} so any argument type is accepted. They
+// are only used for static type checking and are never called.
+var builtinFuncs = FuncMap{
+	"and":      (func(arg0 interface{}, args ...interface{}) interface{})(nil),
+	"call":     (func(fn interface{}, args ...interface{}) (interface{}, error))(nil),
+	"html":     (func(args ...interface{}) string)(nil),
+	"index":    (func(item interface{}, indexes ...interface{}) (interface{}, error))(nil),
+	"slice":    (func(item interface{}, indexes ...interface{}) (interface{}, error))(nil),
+	"js":       (func(args ...interface{}) string)(nil),
+	"len":      (func(item interface{}) (int, error))(nil),
+	"not":      (func(arg interface{}) bool)(nil),
+	"or":       (func(arg0 interface{}, args ...interface{}) interface{})(nil),
+	"print":    (func(args ...interface{}) string)(nil),
+	"printf":   (func(format string, args ...interface{}) string)(nil),
+	"println":  (func(args ...interface{}) string)(nil),
+	"urlquery": (func(args ...interface{}) string)(nil),
+
+	// comparisons
+	"eq": (func(arg1 interface{}, arg2 ...interface{}) (bool, error))(nil),
+	"ge": (func(arg1, arg2 interface{}) (bool, error))(nil),
+	"gt": (func(arg1, arg2 interface{}) (bool, error))(nil),
+	"le": (func(arg1, arg2 interface{}) (bool, error))(nil),
+	"lt": (func(arg1, arg2 interface{}) (bool, error))(nil),
+	"ne": (func(arg1, arg2 interface{}) (bool, error))(nil),
+}
+
+var (
+	registeredFuncsMu sync.RWMutex
+	registeredFuncs   = make(FuncMap)
+)
+
+// RegisterFuncs registers functions that are available to all templates, in
+// addition to the functions given by UseFuncs. Like RegisterAnalyzers, it is
+// meant to be called from an init function so that the functions are also
+// known to the `tmpl check` command.
+func RegisterFuncs(funcs FuncMap) {
+	registeredFuncsMu.Lock()
+	defer registeredFuncsMu.Unlock()
+	for k, v := range funcs {
+		registeredFuncs[k] = v
+	}
+}
+
+// DefaultFuncs returns a copy of all functions registered via RegisterFuncs.
+func DefaultFuncs() FuncMap {
+	registeredFuncsMu.RLock()
+	defer registeredFuncsMu.RUnlock()
+
+	funcs := make(FuncMap, len(registeredFuncs))
+	for k, v := range registeredFuncs {
+		funcs[k] = v
+	}
+	return funcs
+}
+
+// paramType returns the type of the i-th parameter of the given function
+// type, or nil if the function does not accept that many arguments.
+func paramType(fn reflect.Type, i int) reflect.Type {
+	if fn.IsVariadic() && i >= fn.NumIn()-1 {
+		return fn.In(fn.NumIn() - 1).Elem()
+	} else if i < fn.NumIn() {
+		return fn.In(i)
+	}
+	return nil
+}
+
+// isAssignable reports whether a value of type from can be passed as an
+// argument of type to. It mirrors the conversions done by text/template
+// when calling functions and methods.
+func isAssignable(from, to reflect.Type) bool {
+	if from == nil || to == nil {
+		return true
+	} else if from.AssignableTo(to) {
+		return true
+	} else if from.Kind() == reflect.Interface {
+		// the dynamic type is not known until the template is executed
+		return true
+	} else if from.Kind() == reflect.Ptr && from.Elem().AssignableTo(to) {
+		return true
+	} else if reflect.PointerTo(from).AssignableTo(to) {
+		return true
+	}
+	return false
+}
+
+// comparisonKind is the category of a type used by the comparison builtins.
+// It mirrors the basicKind function of text/template.
+type comparisonKind int
+
+const (
+	invalidKind comparisonKind = iota
+	boolKind
+	complexKind
+	intKind
+	floatKind
+	stringKind
+	uintKind
+)
+
+func comparisonKindOf(typ reflect.Type) comparisonKind {
+	switch typ.Kind() {
+	case reflect.Bool:
+		return boolKind
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return intKind
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return uintKind
+	case reflect.Float32, reflect.Float64:
+		return floatKind
+	case reflect.Complex64, reflect.Complex128:
+		return complexKind
+	case reflect.String:
+		return stringKind
+	}
+	return invalidKind
+}
+
+// isIntLike reports whether values of the given type are integers.
+func isIntLike(typ reflect.Type) bool {
+	kind := comparisonKindOf(typ)
+	return kind == intKind || kind == uintKind
+}
+
+// checkComparison checks the types of the arguments passed to one of the
+// comparison builtins and returns a description of the problem, if any.
+// Arguments with an unknown or interface type are skipped because their
+// dynamic type is not known until the template is executed.
+func checkComparison(name string, args []reflect.Type) string {
+	if len(args) == 0 || args[0] == nil || args[0].Kind() == reflect.Interface {
+		return ""
+	}
+
+	arg1 := args[0]
+	k1 := comparisonKindOf(arg1)
+	for _, arg := range args[1:] {
+		if arg == nil || arg.Kind() == reflect.Interface {
+			continue
+		}
+
+		k2 := comparisonKindOf(arg)
+		if k1 != k2 {
+			// integers can be compared regardless of their sign
+			if (k1 == intKind && k2 == uintKind) || (k1 == uintKind && k2 == intKind) {
+				continue
+			}
+			return fmt.Sprintf("incompatible types for %q: %s and %s", name, arg1, arg)
+		}
+
+		switch name {
+		case "eq", "ne":
+			if k1 != invalidKind {
+				continue
+			} else if arg1.Kind() != arg.Kind() {
+				return fmt.Sprintf("incompatible types for %q: %s and %s", name, arg1, arg)
+			} else if !arg.Comparable() {
+				return fmt.Sprintf("non-comparable type for %q: %s", name, arg)
+			}
+		default:
+			if k1 == boolKind || k1 == complexKind || k1 == invalidKind {
+				return fmt.Sprintf("invalid type for %q: %s", name, arg1)
+			}
+		}
+	}
+
+	return ""
+}
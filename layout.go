@@ -0,0 +1,164 @@
+package tmpl
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"reflect"
+	"text/template/parse"
+)
+
+// outletFuncName is the name of the template function that marks where a
+// layout renders the template it wraps: {{ outlet }} or {{ outlet "sidebar" }}
+const outletFuncName = "outlet"
+
+// layoutTagOption is the option of the tmpl struct tag that marks an embedded
+// TemplateProvider as the layout of the struct embedding it:
+//
+//	type LoginPage struct {
+//		AppLayout `tmpl:"app,layout"`
+//	}
+const layoutTagOption = "layout"
+
+// unwiredOutlet is the outlet function called when an outlet has not been
+// replaced by Compile, because it is not the only command of its action or
+// because it is not in the template of a layout.
+func unwiredOutlet(name ...string) (string, error) {
+	return "", errors.New("outlet must be used as {{ outlet }} or {{ outlet \"name\" }} in the template of a layout")
+}
+
+// resolveLayouts follows the chain of layouts starting at the given
+// TemplateProvider, which provides the template with the given name. It returns
+// a map of the name of each layout to the name of the template rendered by its
+// outlet, and the name of the outermost layout. The outermost layout is empty
+// if the TemplateProvider has no layout.
+func resolveLayouts(tp TemplateProvider, name string) (outlets map[string]string, outermost string, err error) {
+	outlets = make(map[string]string)
+
+	typ := indirectType(reflect.TypeOf(tp))
+	for typ.Kind() == reflect.Struct {
+		field, ok := layoutField(typ)
+		if !ok {
+			break
+		}
+		if !field.Anonymous {
+			return nil, "", fmt.Errorf("layout %s of %s must be an embedded field", field.Name, typ)
+		}
+		if !reflect.PointerTo(indirectType(field.Type)).Implements(reflect.TypeOf((*TemplateProvider)(nil)).Elem()) {
+			return nil, "", fmt.Errorf("layout %s of %s must be a TemplateProvider", field.Name, typ)
+		}
+
+		layout := templateNameOf(field, field.Name)
+		if _, ok := outlets[layout]; ok || layout == name {
+			return nil, "", fmt.Errorf("layout %q of %s wraps itself", layout, typ)
+		}
+
+		outlets[layout] = name
+		name, outermost = layout, layout
+		typ = indirectType(field.Type)
+	}
+
+	return outlets, outermost, nil
+}
+
+// layoutField returns the field of the given struct type tagged as its layout.
+func layoutField(typ reflect.Type) (reflect.StructField, bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		if hasTemplateTagOption(typ.Field(i), layoutTagOption) {
+			return typ.Field(i), true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// wireOutlets replaces the outlets in the template of each layout with calls of
+// the templates they render: {{ outlet }} becomes a call of the template the
+// layout wraps, and {{ outlet "sidebar" }} a call of the template named
+// "sidebar". Named outlets that no template fills are removed.
+func wireOutlets(t *template.Template, outlets map[string]string) {
+	for layout, child := range outlets {
+		lt := t.Lookup(layout)
+		if lt == nil || lt.Tree == nil || lt.Tree.Root == nil {
+			continue
+		}
+		wireOutletsInList(t, lt.Tree.Root, child)
+	}
+}
+
+func wireOutletsInList(t *template.Template, list *parse.ListNode, child string) {
+	if list == nil {
+		return
+	}
+
+	nodes := make([]parse.Node, 0, len(list.Nodes))
+	for _, node := range list.Nodes {
+		switch n := node.(type) {
+		case *parse.ActionNode:
+			if name, ok := outletName(n); ok {
+				if len(name) == 0 {
+					name = child
+				} else if t.Lookup(name) == nil {
+					continue
+				}
+				nodes = append(nodes, &parse.TemplateNode{
+					NodeType: parse.NodeTemplate,
+					Pos:      n.Pos,
+					Line:     n.Line,
+					Name:     name,
+					Pipe:     rootPipe(n.Pos, n.Line),
+				})
+				continue
+			}
+		case *parse.IfNode:
+			wireOutletsInList(t, n.List, child)
+			wireOutletsInList(t, n.ElseList, child)
+		case *parse.RangeNode:
+			wireOutletsInList(t, n.List, child)
+			wireOutletsInList(t, n.ElseList, child)
+		case *parse.WithNode:
+			wireOutletsInList(t, n.List, child)
+			wireOutletsInList(t, n.ElseList, child)
+		}
+		nodes = append(nodes, node)
+	}
+	list.Nodes = nodes
+}
+
+// outletName returns the name of the outlet if the given action consists of
+// nothing but a call of the outlet function. The default outlet has no name.
+func outletName(node *parse.ActionNode) (string, bool) {
+	if node.Pipe == nil || len(node.Pipe.Decl) != 0 || len(node.Pipe.Cmds) != 1 {
+		return "", false
+	}
+
+	args := node.Pipe.Cmds[0].Args
+	if ident, ok := args[0].(*parse.IdentifierNode); !ok || ident.Ident != outletFuncName {
+		return "", false
+	}
+
+	switch len(args) {
+	case 1:
+		return "", true
+	case 2:
+		if str, ok := args[1].(*parse.StringNode); ok {
+			return str.Text, true
+		}
+	}
+	return "", false
+}
+
+// rootPipe returns the pipeline {{ $ }} at the given position. Outlets pass
+// the data of the whole page, even where dot has been changed by a with or
+// range action.
+func rootPipe(pos parse.Pos, line int) *parse.PipeNode {
+	return &parse.PipeNode{
+		NodeType: parse.NodePipe,
+		Pos:      pos,
+		Line:     line,
+		Cmds: []*parse.CommandNode{{
+			NodeType: parse.NodeCommand,
+			Pos:      pos,
+			Args:     []parse.Node{&parse.VariableNode{NodeType: parse.NodeVariable, Pos: pos, Ident: []string{"$"}}},
+		}},
+	}
+}
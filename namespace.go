@@ -0,0 +1,103 @@
+package tmpl
+
+import (
+	"fmt"
+	"html/template"
+	"reflect"
+	"text/template/parse"
+)
+
+// namespaceSeparator separates the name of a nested template from the names of
+// the templates it defines when namespacing is enabled: {{ template "form.input" }}
+const namespaceSeparator = "."
+
+// namespaceTrees prefixes the names of the templates defined in the text of the
+// template with the given name, which are all given trees except its own. Calls
+// of those templates within the given trees are renamed accordingly. It
+// returns the given trees by their new names.
+func namespaceTrees(name string, trees map[string]*parse.Tree) map[string]*parse.Tree {
+	renamed := make(map[string]string, len(trees))
+	for k := range trees {
+		if k != name {
+			renamed[k] = name + namespaceSeparator + k
+		}
+	}
+
+	res := make(map[string]*parse.Tree, len(trees))
+	for k, tree := range trees {
+		if newName, ok := renamed[k]; ok {
+			k = newName
+			tree.Name = newName
+		}
+		res[k] = tree
+
+		if tree.Root == nil {
+			continue
+		}
+		Traverse(tree.Root, func(node parse.Node) {
+			if n, ok := node.(*parse.TemplateNode); ok {
+				if newName, ok := renamed[n.Name]; ok {
+					n.Name = newName
+				}
+			}
+		})
+	}
+
+	return res
+}
+
+// parseNamespaced parses the text of the nested template with the given name
+// into a template of its own, so that the templates it defines cannot replace
+// any templates of t, and then adds them to t with namespaced names.
+func parseNamespaced(t *template.Template, name string, text string, opts ParseOptions, funcs FuncMap) error {
+	nt, err := template.New(name).Delims(opts.LeftDelim, opts.RightDelim).Funcs(funcs).Parse(text)
+	if err != nil {
+		return err
+	}
+
+	trees := make(map[string]*parse.Tree)
+	for _, tt := range nt.Templates() {
+		if tt.Tree != nil {
+			trees[tt.Name()] = tt.Tree
+		}
+	}
+
+	for k, tree := range namespaceTrees(name, trees) {
+		if _, err := t.AddParseTree(k, tree); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addTree adds the given tree to the tree set under the given name. If another
+// TemplateProvider already defined a template of the same name, an error is
+// reported at the locations of both definitions. Definitions without content
+// are ignored, like they are by the Go template packages.
+func (h *AnalysisHelper) addTree(name string, tree *parse.Tree, owner reflect.Type) {
+	other, ok := h.treeSet[name]
+	if !ok || (isEmptyTree(other) && !isEmptyTree(tree)) {
+		h.treeSet[name] = tree
+		h.owners[name] = owner
+		return
+	}
+
+	// the same TemplateProvider can be nested more than once
+	if h.owners[name] == owner || isEmptyTree(tree) {
+		return
+	}
+
+	key := fmt.Sprintf("%s\x00%s", name, owner)
+	if h.duplicates[key] {
+		return
+	}
+	h.duplicates[key] = true
+
+	first := newDiagnostic(other, other.Root, h.sources, SeverityError, "", "")
+	h.diagnostics = append(h.diagnostics, newDiagnostic(tree, tree.Root, h.sources, SeverityError, CodeDuplicateTemplate,
+		fmt.Sprintf("template %q is defined by both %s and %s: first defined at %s", name, indirectType(h.owners[name]), indirectType(owner), first.Location())))
+}
+
+func isEmptyTree(tree *parse.Tree) bool {
+	return tree == nil || tree.Root == nil || parse.IsEmptyTree(tree.Root)
+}
@@ -0,0 +1,232 @@
+package tmpl
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// FileProvider is a TemplateProvider that loads its template text from files
+// in a fs.FS. The files matching each of its patterns are read and concatenated
+// every time TemplateText is called.
+//
+// FileProvider also implements TemplateWatcher. When polling is enabled via
+// WithPolling, the modification times of the matched files are checked on an
+// interval and any Template compiled from this provider is recompiled when
+// a file is added, removed or changed. This is intended for use during
+// development, when templates should be reloaded without restarting the program.
+// Polling stops when no Template watches the FileProvider anymore, or when it
+// is closed using Close.
+//
+// FileProvider is not meant to be embedded in a dot context struct because the
+// compiler only sees zero values of nested fields. Instead, declare it as a
+// package level variable and delegate to it:
+//
+//	var loginPageFiles = tmpl.NewFileProvider(os.DirFS("templates"), "login.tmpl.html")
+//
+//	func (*LoginPage) TemplateText() string { return loginPageFiles.TemplateText() }
+//
+//	func (*LoginPage) Watch() <-chan struct{} { return loginPageFiles.Watch() }
+//
+//	func (*LoginPage) Unwatch(ch <-chan struct{}) { loginPageFiles.Unwatch(ch) }
+type FileProvider struct {
+	fsys     fs.FS
+	patterns []string
+	interval time.Duration
+
+	// mu guards the fields below
+	mu sync.Mutex
+	// stop stops the polling routine, or is nil if it is not running
+	stop chan struct{}
+	// closed is true once Close has been called
+	closed bool
+	// subscribers are the channels returned by Watch
+	subscribers []chan struct{}
+}
+
+// NewFileProvider creates a FileProvider that loads template text from the files
+// in fsys matching the given patterns. The pattern syntax is the same as fs.Glob.
+func NewFileProvider(fsys fs.FS, patterns ...string) *FileProvider {
+	return &FileProvider{
+		fsys:        fsys,
+		patterns:    patterns,
+		subscribers: make([]chan struct{}, 0),
+	}
+}
+
+// WithPolling enables polling of the matched files on the given interval. A zero
+// interval disables polling.
+func (p *FileProvider) WithPolling(interval time.Duration) *FileProvider {
+	p.interval = interval
+	return p
+}
+
+// TemplateText reads and concatenates all the files matching the FileProvider's
+// patterns. It panics if a pattern is malformed, matches no files or if a file
+// cannot be read.
+func (p *FileProvider) TemplateText() string {
+	paths, err := p.glob()
+	if err != nil {
+		panic(err)
+	}
+
+	buf := bytes.Buffer{}
+	for _, path := range paths {
+		byt, err := fs.ReadFile(p.fsys, path)
+		if err != nil {
+			panic(err)
+		}
+		buf.Write(byt)
+	}
+
+	return buf.String()
+}
+
+// Watch returns a channel that receives a signal when any of the matched files
+// change. If polling is disabled or the FileProvider is closed, Watch returns
+// nil. The polling routine is started by the first call of Watch.
+func (p *FileProvider) Watch() <-chan struct{} {
+	if p.interval <= 0 {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+
+	ch := make(chan struct{}, 1)
+	p.subscribers = append(p.subscribers, ch)
+
+	if p.stop == nil {
+		p.stop = make(chan struct{})
+		go p.poll(p.stop, p.snapshot())
+	}
+
+	return ch
+}
+
+// Unwatch closes the given channel returned by Watch and stops signaling it.
+// The polling routine is stopped once no channel is left, and started again
+// by the next call of Watch.
+func (p *FileProvider) Unwatch(ch <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, sub := range p.subscribers {
+		if (<-chan struct{})(sub) == ch {
+			p.subscribers = append(p.subscribers[:i], p.subscribers[i+1:]...)
+			close(sub)
+			break
+		}
+	}
+
+	if len(p.subscribers) == 0 {
+		p.stopPolling()
+	}
+}
+
+// Close stops the polling routine and closes all channels returned by Watch,
+// so that the Templates compiled from the FileProvider stop watching it. Once
+// closed, Watch returns nil.
+func (p *FileProvider) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for _, ch := range p.subscribers {
+		close(ch)
+	}
+	p.subscribers = make([]chan struct{}, 0)
+	p.stopPolling()
+
+	return nil
+}
+
+// stopPolling stops the polling routine if it is running. It must be called
+// while holding mu.
+func (p *FileProvider) stopPolling() {
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+}
+
+// poll checks the state of the matched files on the configured interval
+// and notifies all subscribers when it differs from the last snapshot,
+// until the given channel is closed.
+func (p *FileProvider) poll(stop <-chan struct{}, last string) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		cur := p.snapshot()
+		if cur == last {
+			continue
+		}
+		last = cur
+
+		p.mu.Lock()
+		// the routine may have been stopped while taking the snapshot
+		select {
+		case <-stop:
+			p.mu.Unlock()
+			return
+		default:
+		}
+		for _, ch := range p.subscribers {
+			// subscribers that have not yet handled the last
+			// signal do not need to be signaled again
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+		p.mu.Unlock()
+	}
+}
+
+// snapshot returns a string describing the name, size and modification
+// time of every matched file.
+func (p *FileProvider) snapshot() string {
+	paths, err := p.glob()
+	if err != nil {
+		return err.Error()
+	}
+
+	buf := bytes.Buffer{}
+	for _, path := range paths {
+		info, err := fs.Stat(p.fsys, path)
+		if err != nil {
+			buf.WriteString(err.Error())
+			continue
+		}
+		buf.WriteString(fmt.Sprintf("%s:%d:%d\n", path, info.Size(), info.ModTime().UnixNano()))
+	}
+
+	return buf.String()
+}
+
+func (p *FileProvider) glob() ([]string, error) {
+	paths := make([]string, 0)
+	for _, pattern := range p.patterns {
+		matches, err := fs.Glob(p.fsys, pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to glob pattern '%s': %v", pattern, err)
+		} else if len(matches) == 0 {
+			return nil, fmt.Errorf("pattern '%s' did not match any files", pattern)
+		}
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
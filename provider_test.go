@@ -0,0 +1,217 @@
+package tmpl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fileProvidedTemplate struct {
+	Text string
+}
+
+var fileProvidedTemplateFiles *FileProvider
+
+func (*fileProvidedTemplate) TemplateText() string {
+	return fileProvidedTemplateFiles.TemplateText()
+}
+
+func (*fileProvidedTemplate) Watch() <-chan struct{} {
+	return fileProvidedTemplateFiles.Watch()
+}
+
+func (*fileProvidedTemplate) Unwatch(ch <-chan struct{}) {
+	fileProvidedTemplateFiles.Unwatch(ch)
+}
+
+// Test_FileProvider tests that templates loaded by a polling FileProvider
+// are recompiled when the underlying file changes.
+func Test_FileProvider(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "index.tmpl.html")
+	if err := os.WriteFile(file, []byte("<p>{{ .Text }}</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fileProvidedTemplateFiles = NewFileProvider(os.DirFS(dir), "*.tmpl.html").WithPolling(10 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tmpl, err := Compile(&fileProvidedTemplate{}, UseContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := tmpl.RenderToString(&fileProvidedTemplate{Text: "Hello World"})
+	if err != nil {
+		t.Fatal(err)
+	} else if res != "<p>Hello World</p>" {
+		t.Fatalf("expected render output to be %q, got %q", "<p>Hello World</p>", res)
+	}
+
+	if err := os.WriteFile(file, []byte("<span>{{ .Text }}</span>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// make sure the modification time changes on file systems with coarse timestamps
+	if err := os.Chtimes(file, time.Now(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		res, err = tmpl.RenderToString(&fileProvidedTemplate{Text: "Hello World"})
+		if err != nil {
+			t.Fatal(err)
+		} else if res == "<span>Hello World</span>" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("expected template to be recompiled, got %q", res)
+}
+
+type unwatchedTemplate struct {
+	Text string
+}
+
+var unwatchedTemplateFiles *FileProvider
+
+func (*unwatchedTemplate) TemplateText() string {
+	return unwatchedTemplateFiles.TemplateText()
+}
+
+func (*unwatchedTemplate) Watch() <-chan struct{} {
+	return unwatchedTemplateFiles.Watch()
+}
+
+func (*unwatchedTemplate) Unwatch(ch <-chan struct{}) {
+	unwatchedTemplateFiles.Unwatch(ch)
+}
+
+// Test_FileProviderStopsPolling tests that a FileProvider stops polling once
+// no Template watches it anymore, and once it is closed.
+func Test_FileProviderStopsPolling(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.tmpl.html"), []byte("<p>{{ .Text }}</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// polling reports whether the polling routine is running and how many
+	// channels are signaled by it
+	polling := func(p *FileProvider) (bool, int) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.stop != nil, len(p.subscribers)
+	}
+
+	t.Run("Stops polling when the context is cancelled", func(t *testing.T) {
+		unwatchedTemplateFiles = NewFileProvider(os.DirFS(dir), "*.tmpl.html").WithPolling(10 * time.Millisecond)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		if _, err := Compile(&unwatchedTemplate{}, UseContext(ctx)); err != nil {
+			t.Fatal(err)
+		}
+		if running, _ := polling(unwatchedTemplateFiles); !running {
+			t.Fatal("expected FileProvider to poll while the template is watched")
+		}
+
+		cancel()
+
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			if running, subscribers := polling(unwatchedTemplateFiles); !running && subscribers == 0 {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("expected FileProvider to stop polling once the context is cancelled")
+	})
+
+	t.Run("Stops polling when closed", func(t *testing.T) {
+		p := NewFileProvider(os.DirFS(dir), "*.tmpl.html").WithPolling(10 * time.Millisecond)
+
+		ch := p.Watch()
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := <-ch; ok {
+			t.Fatal("expected Close to close the channels returned by Watch")
+		}
+		if running, subscribers := polling(p); running || subscribers != 0 {
+			t.Fatalf("expected FileProvider to stop polling once closed, got running=%v subscribers=%d", running, subscribers)
+		}
+		if p.Watch() != nil {
+			t.Fatal("expected Watch to return nil once closed")
+		}
+	})
+}
+
+type removedTemplate struct {
+	Text string
+}
+
+var removedTemplateFiles *FileProvider
+
+func (*removedTemplate) TemplateText() string {
+	return removedTemplateFiles.TemplateText()
+}
+
+func (*removedTemplate) Watch() <-chan struct{} {
+	return removedTemplateFiles.Watch()
+}
+
+func (*removedTemplate) Unwatch(ch <-chan struct{}) {
+	removedTemplateFiles.Unwatch(ch)
+}
+
+// Test_FileProviderRemovedFile tests that removing the file of a polling
+// FileProvider reports an error and keeps the previously compiled template.
+func Test_FileProviderRemovedFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "index.tmpl.html")
+	if err := os.WriteFile(file, []byte("<p>{{ .Text }}</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	removedTemplateFiles = NewFileProvider(os.DirFS(dir), "*.tmpl.html").WithPolling(10 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errs := make(chan error, 1)
+	tmpl, err := Compile(&removedTemplate{}, UseContext(ctx), UseWatchErrorHandler(func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "did not match any files") {
+			t.Fatalf("expected the recompilation to fail because the file is missing, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the recompilation to fail once the file is removed")
+	}
+
+	res, err := tmpl.RenderToString(&removedTemplate{Text: "Hello World"})
+	if err != nil {
+		t.Fatal(err)
+	} else if res != "<p>Hello World</p>" {
+		t.Fatalf("expected render output to be %q, got %q", "<p>Hello World</p>", res)
+	}
+}
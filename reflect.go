@@ -3,50 +3,191 @@ package tmpl
 import (
 	"fmt"
 	"reflect"
+	"strings"
 )
 
 type FieldNode struct {
 	Value       reflect.Value
 	StructField reflect.StructField
 
+	// Method is the signature of the method this node represents, without
+	// the receiver, or nil if the node is not a method. The StructField type
+	// of a method is the type of its first result. Since methods can return
+	// the type they are defined on, the Children of a method are only created
+	// once they are looked up via FindPath.
+	Method reflect.Type
+
 	Parent   *FieldNode
 	Children []*FieldNode
 }
 
+// Type returns the type of the field. The root of a field tree does not
+// have a StructField type, so the type of its value is used instead.
+func (node *FieldNode) Type() reflect.Type {
+	if node.StructField.Type != nil {
+		return node.StructField.Type
+	} else if node.Value.IsValid() {
+		return node.Value.Type()
+	}
+	return nil
+}
+
 func (node *FieldNode) IsKind(kind reflect.Kind) (reflect.Kind, bool) {
-	if node.StructField.Type.Kind() == reflect.Interface && node.Value.Kind() != kind {
-		return node.Value.Kind(), false
-	} else if node.StructField.Type.Kind() != kind {
-		return node.StructField.Type.Kind(), false
-	} else {
-		return kind, true
+	if actual := node.GetKind(); actual != kind {
+		return actual, false
 	}
+	return kind, true
 }
 
+// GetKind returns the kind of the field's type. The kind of an interface
+// field is reflect.Interface, unless the field tree was created from the
+// concrete values of a TemplateProvider.
 func (node *FieldNode) GetKind() reflect.Kind {
-	if node.StructField.Type.Kind() == reflect.Interface {
-		return node.Value.Kind()
-	} else {
-		return node.StructField.Type.Kind()
+	if typ := node.Type(); typ != nil {
+		return typ.Kind()
 	}
+	return reflect.Invalid
 }
 
+// FindPath returns the FieldNode at the given path of field names relative to
+// this node, or nil if it is not defined. Like in text/template, the keys of
+// maps with string keys can be used as path segments: {{ .Labels.env }}
 func (node *FieldNode) FindPath(path []string) *FieldNode {
 	if len(path) == 0 {
 		return node
 	}
 
-	for _, child := range node.Children {
+	for _, child := range node.children() {
 		if child.StructField.Name == path[0] {
 			return child.FindPath(path[1:])
 		}
 	}
 
+	if typ := node.Type(); typ != nil {
+		typ = indirectType(typ)
+		if typ.Kind() == reflect.Map && reflect.TypeOf("").AssignableTo(typ.Key()) {
+			elem, err := createFieldTreeFromType(path[0], typ.Elem())
+			if err != nil {
+				return nil
+			}
+			elem.Parent = node
+			return elem.FindPath(path[1:])
+		}
+	}
+
 	return nil
 }
 
+// children returns the Children of the node, creating the children of a
+// method from the type of its result on first access.
+func (node *FieldNode) children() []*FieldNode {
+	if node.Method == nil || node.Children != nil {
+		return node.Children
+	}
+
+	node.Children = make([]*FieldNode, 0)
+	if typ := node.Type(); typ != nil {
+		tree, err := createFieldTreeFromType(node.StructField.Name, typ)
+		if err != nil {
+			return node.Children
+		}
+		for _, child := range tree.Children {
+			child.Parent = node
+			node.Children = append(node.Children, child)
+		}
+	}
+
+	return node.Children
+}
+
+// createMethodNodes creates a FieldNode for each exported method in the
+// method set of the given type. The method set of an interface type is the
+// set of methods it declares.
+func createMethodNodes(parent *FieldNode, typ reflect.Type) []*FieldNode {
+	// the signatures of interface methods do not include a receiver
+	recv := 1
+	if typ.Kind() == reflect.Interface {
+		recv = 0
+	}
+
+	nodes := make([]*FieldNode, 0, typ.NumMethod())
+	for i := 0; i < typ.NumMethod(); i++ {
+		method := typ.Method(i)
+
+		// drop the receiver from the method's signature
+		in := make([]reflect.Type, 0, method.Type.NumIn())
+		for j := recv; j < method.Type.NumIn(); j++ {
+			in = append(in, method.Type.In(j))
+		}
+		out := make([]reflect.Type, 0, method.Type.NumOut())
+		for j := 0; j < method.Type.NumOut(); j++ {
+			out = append(out, method.Type.Out(j))
+		}
+
+		node := &FieldNode{
+			StructField: reflect.StructField{
+				Name: method.Name,
+			},
+			Method: reflect.FuncOf(in, out, method.Type.IsVariadic()),
+			Parent: parent,
+		}
+		if len(out) > 0 {
+			node.Value = reflect.New(out[0]).Elem()
+			node.StructField.Type = out[0]
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// createTypeNode creates a FieldNode without children for a value of the given
+// type. It is used to represent the type of values that are not struct fields,
+// such as constants in a template.
+func createTypeNode(name string, typ reflect.Type) *FieldNode {
+	return &FieldNode{
+		Value: reflect.New(typ).Elem(),
+		StructField: reflect.StructField{
+			Name: name,
+			Type: typ,
+		},
+		Children: make([]*FieldNode, 0),
+	}
+}
+
+// createFieldTreeFromType creates a field tree for a zero value of the given
+// type. Pointer types are dereferenced, so the tree of *T and T are the same.
+func createFieldTreeFromType(name string, typ reflect.Type) (*FieldNode, error) {
+	var iface interface{}
+	if typ.Kind() == reflect.Ptr {
+		iface = reflect.New(typ.Elem()).Interface()
+	} else {
+		iface = reflect.New(typ).Interface()
+	}
+
+	root, err := createFieldTree(iface)
+	if err != nil {
+		return nil, err
+	}
+	root.StructField.Name = name
+	root.StructField.Type = typ
+
+	return root, nil
+}
+
 // createFieldTree can be used to create a tree structure of the fields in a struct
 func createFieldTree(structOrPtr interface{}) (root *FieldNode, err error) {
+	return buildFieldTree(structOrPtr, false)
+}
+
+// createConcreteFieldTree is like createFieldTree but uses the values of the
+// fields of the given struct rather than zero values. Interface fields holding
+// a non-nil value are described by the type of that value instead of the
+// declared method set of the interface.
+func createConcreteFieldTree(structOrPtr interface{}) (root *FieldNode, err error) {
+	return buildFieldTree(structOrPtr, true)
+}
+
+func buildFieldTree(structOrPtr interface{}, concrete bool) (root *FieldNode, err error) {
 	root = &FieldNode{
 		Value: reflect.ValueOf(structOrPtr),
 		StructField: reflect.StructField{
@@ -58,61 +199,17 @@ func createFieldTree(structOrPtr interface{}) (root *FieldNode, err error) {
 	}
 
 	if root.Value.Kind() == reflect.Ptr {
-		// detect all methods on this pointer
-		val := root.Value
-		for i := 0; i < val.NumMethod(); i++ {
-			methodVal := val.Method(i)
-			methodTyp := val.Type().Method(i)
-
-			node := &FieldNode{
-				Value: methodVal,
-				StructField: reflect.StructField{
-					Name: methodTyp.Name,
-					Type: methodTyp.Type,
-				},
-				Parent:   root,
-				Children: make([]*FieldNode, 0),
-			}
-			root.Children = append(root.Children, node)
-
-			// for each of the values returned by this method,
-			// create a field tree and append it as a child
-			for j := 0; j < methodVal.Type().NumOut(); j++ {
-				retTyp := methodVal.Type().Out(j)
-				var retVal reflect.Value
-				if retTyp.Kind() == reflect.Ptr {
-					retVal = reflect.New(retTyp.Elem())
-				} else {
-					retVal = reflect.New(retTyp).Elem()
-				}
-
-			retTypSwitch:
-				switch retTyp.Kind() {
-				case reflect.Ptr:
-					fallthrough
-				case reflect.Struct:
-					// check for circular dependencies, if found, append the parent node
-					// as a child of the returned tree instead of recurring again
-					for temp := node.Parent; temp != nil; temp = temp.Parent {
-						if temp.Value.Type() == retTyp {
-							node.Children = append(node.Children, temp.Children...)
-							break retTypSwitch
-						}
-					}
-
-					tree, err := createFieldTree(retVal.Interface())
-					if err != nil {
-						return root, err
-					}
-					// the children of the returned tree should be children of this node
-					node.Children = append(node.Children, tree.Children...)
-				}
-			}
-
-		}
+		// detect all methods on this pointer, including
+		// the methods with a value receiver
+		root.Children = append(root.Children, createMethodNodes(root, root.Value.Type())...)
 
 		// convert this pointer to a value
-		root.Value = val.Elem()
+		root.Value = root.Value.Elem()
+	}
+
+	if root.Value.Kind() == reflect.Interface {
+		// only the declared method set of an interface is known
+		root.Children = append(root.Children, createMethodNodes(root, root.Value.Type())...)
 	}
 
 	if root.Value.Kind() != reflect.Struct {
@@ -121,9 +218,29 @@ func createFieldTree(structOrPtr interface{}) (root *FieldNode, err error) {
 
 	val := root.Value
 	for i := 0; i < val.NumField(); i++ {
-		iface := zeroValueInterfaceFromField(val.Field(i))
+		field := val.Field(i)
+		if concrete && field.Kind() == reflect.Interface && !field.IsNil() && field.CanInterface() {
+			node, err := buildFieldTree(addressableInterface(field.Elem()), concrete)
+			if err != nil {
+				return nil, err
+			}
+			node.StructField = val.Type().Field(i)
+			node.StructField.Type = field.Elem().Type()
+			node.Parent = root
+			root.Children = append(root.Children, node)
+			continue
+		}
+
+		iface := zeroValueInterfaceFromField(field)
+		if concrete && iface != nil && field.CanInterface() {
+			if field.Kind() == reflect.Struct && field.CanAddr() {
+				iface = field.Addr().Interface()
+			} else if field.Kind() == reflect.Ptr && !field.IsNil() {
+				iface = field.Interface()
+			}
+		}
 		if iface != nil {
-			node, err := createFieldTree(iface)
+			node, err := buildFieldTree(iface, concrete)
 			if err != nil {
 				return nil, err
 			}
@@ -156,6 +273,13 @@ func createFieldTree(structOrPtr interface{}) (root *FieldNode, err error) {
 				Parent:   root,
 				Children: make([]*FieldNode, 0),
 			}
+			// fields are addressable, so the methods with a
+			// pointer receiver can be called on them as well
+			if typ := node.StructField.Type; typ.Kind() == reflect.Interface {
+				node.Children = append(node.Children, createMethodNodes(node, typ)...)
+			} else {
+				node.Children = append(node.Children, createMethodNodes(node, reflect.PointerTo(typ))...)
+			}
 			root.Children = append(root.Children, node)
 		}
 	}
@@ -163,6 +287,90 @@ func createFieldTree(structOrPtr interface{}) (root *FieldNode, err error) {
 	return root, nil
 }
 
+// addressableInterface returns the given value as an interface{}. Values that
+// are not pointers are copied to a new pointer so that their methods with a
+// pointer receiver are found by buildFieldTree.
+func addressableInterface(val reflect.Value) interface{} {
+	if val.Kind() == reflect.Ptr {
+		return val.Interface()
+	}
+	ptr := reflect.New(val.Type())
+	ptr.Elem().Set(val)
+	return ptr.Interface()
+}
+
+// isEmptyInterface reports whether the given type is an interface type
+// without any methods, such as any. Nothing is known about the values of
+// such types until the template is executed.
+func isEmptyInterface(typ reflect.Type) bool {
+	return typ != nil && typ.Kind() == reflect.Interface && typ.NumMethod() == 0
+}
+
+// indirectType dereferences the given type until it is not a pointer.
+func indirectType(typ reflect.Type) reflect.Type {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ
+}
+
+// embedsType reports whether typ is the target type or a struct that embeds
+// the target type, either directly or through other embedded structs. Pointers
+// are dereferenced on both sides.
+func embedsType(typ, target reflect.Type) bool {
+	typ, target = indirectType(typ), indirectType(target)
+	if typ == target {
+		return true
+	} else if typ.Kind() != reflect.Struct {
+		return false
+	}
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.Anonymous && embedsType(field.Type, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTemplateTag returns the template name and the options of the tmpl
+// struct tag of the given field: `tmpl:"name,option"`. The name is empty if
+// the field has no tmpl tag or if the tag does not specify a name.
+func parseTemplateTag(field reflect.StructField) (name string, opts []string) {
+	tag, ok := field.Tag.Lookup("tmpl")
+	if !ok {
+		return "", nil
+	}
+
+	parts := strings.Split(tag, ",")
+	for _, opt := range parts[1:] {
+		opts = append(opts, strings.TrimSpace(opt))
+	}
+	return parts[0], opts
+}
+
+// hasTemplateTagOption reports whether the tmpl struct tag of the given field
+// has the given option.
+func hasTemplateTagOption(field reflect.StructField, option string) bool {
+	_, opts := parseTemplateTag(field)
+	for _, opt := range opts {
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+// templateNameOf returns the name of the template provided by the given field.
+// If the field has no tmpl tag, the given fallback is returned.
+func templateNameOf(field reflect.StructField, fallback string) string {
+	if name, _ := parseTemplateTag(field); len(name) != 0 {
+		return name
+	}
+	return fallback
+}
+
 func recurseFieldsImplementing[T interface{}](structOrPtr interface{}, fn func(val T, field reflect.StructField) error) error {
 	val := reflect.ValueOf(structOrPtr)
 	if val.Kind() == reflect.Ptr {
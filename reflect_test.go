@@ -44,6 +44,13 @@ func Test_createFieldTree(t *testing.T) {
 				".Method3.Method1",
 			},
 		},
+		{
+			name:        "Detects methods of method results",
+			structOrPtr: &testFieldTree{},
+			wantFields: []string{
+				".Method4.Method3.Method2.Field1",
+			},
+		},
 	}
 
 	for _, tt := range testTable {
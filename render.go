@@ -2,24 +2,147 @@ package tmpl
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"html/template"
 	"io"
+	"net/http"
+	"text/template/parse"
 )
 
+// flushFuncName is the name of the template function that flushes the
+// http.Flusher being rendered to when streaming: {{ flush }}
+const flushFuncName = "flush"
+
+// noopFlush is the flush function used when a Template is not streamed. It
+// is also what the analyzers know the signature of the flush function from.
+func noopFlush() string {
+	return ""
+}
+
+// contextFuncName is the name of the template function that gives access to
+// the context passed to RenderContext: {{ ctx }} or {{ ctx "key" }}
+const contextFuncName = "ctx"
+
+// ContextKey is the type of the keys of context values that can be accessed
+// in a template using the ctx function. The value stored under
+// ContextKey("userID") is returned by {{ ctx "userID" }}.
+type ContextKey string
+
+// contextFunc returns the ctx template function bound to the given context.
+// Without arguments, it returns the context itself, so that it can be passed
+// to other functions: {{ csrfToken ctx }}
+func contextFunc(ctx context.Context) func(key ...string) (interface{}, error) {
+	return func(key ...string) (interface{}, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		switch len(key) {
+		case 0:
+			return ctx, nil
+		case 1:
+			return ctx.Value(ContextKey(key[0])), nil
+		}
+		return nil, fmt.Errorf("ctx expects at most 1 key, got %d", len(key))
+	}
+}
+
+// boundFuncNames are the names of the template functions that are bound to
+// the arguments of each call of RenderContext.
+var boundFuncNames = []string{flushFuncName, contextFuncName}
+
+// boundTemplate is a clone of a Template whose bound functions call its
+// fields, which are set for each call of RenderContext that executes it.
+type boundTemplate struct {
+	template *template.Template
+	ctx      context.Context
+	flush    func()
+}
+
+// funcs returns the bound functions that call the fields of b.
+func (b *boundTemplate) funcs() template.FuncMap {
+	return template.FuncMap{
+		flushFuncName: func() string {
+			b.flush()
+			return ""
+		},
+		contextFuncName: func(key ...string) (interface{}, error) {
+			return contextFunc(b.ctx)(key...)
+		},
+	}
+}
+
+// reset unsets the fields of b, so that the context of the last call is
+// not kept alive by b.
+func (b *boundTemplate) reset() {
+	b.ctx = context.Background()
+	b.flush = func() {}
+}
+
+// contextWriter is an io.Writer that fails once its context is done, which
+// aborts the execution of a template at its next write.
+type contextWriter struct {
+	ctx context.Context
+	wr  io.Writer
+}
+
+func (w *contextWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.wr.Write(p)
+}
+
+// RenderProcess holds the state of a call of Render that RenderOptions can
+// change. The compiled Template is shared by all calls of Render, so it is not
+// exposed here. Use Mutate to change the Template being rendered.
 type RenderProcess struct {
-	Targets  []string
-	Template *template.Template
+	Targets []string
+
+	// Stream is true if the Template is executed directly into the
+	// writer passed to Render instead of a buffer.
+	Stream bool
+
+	// mutations are the changes to the Template requested via Mutate
+	mutations []mutation
+	// targetData is the data the targets are executed with, if it is not
+	// the data passed to Render
+	targetData map[string]targetData
+}
+
+// mutation is a change to the Template requested by a RenderOption
+type mutation struct {
+	key string
+	fn  func(t *template.Template) (*template.Template, error)
+}
+
+// Mutate requests a change to the Template being rendered. The changes of all
+// RenderOptions are applied in order to a clone of the compiled Template.
+//
+// Clones are cached by the keys of their changes, so the Template is cloned
+// only once for each combination of keys. The key must therefore identify the
+// change completely, and should come from a fixed set: only a limited number
+// of clones are kept, so keys derived from request data cause clones to be
+// dropped and made again. If the key is empty, the change is not cached and the
+// Template is cloned on every call of Render.
+func (p *RenderProcess) Mutate(key string, fn func(t *template.Template) (*template.Template, error)) {
+	p.mutations = append(p.mutations, mutation{key: key, fn: fn})
 }
 
+// RenderOption customizes a call of Render. Options that only read the
+// RenderProcess, such as WithTarget, are free. Options that change the
+// Template, such as WithName, cause a clone of the Template to be rendered.
 type RenderOption func(p *RenderProcess)
 
 // WithName copies the Template's default parse.Tree and adds it back
 // to the Template under the given name, effectively aliasing the Template.
 func WithName(name string) RenderOption {
 	return func(p *RenderProcess) {
-		p.Template = template.Must(p.Template.AddParseTree(name, p.Template.Tree.Copy()))
+		p.Mutate("name:"+name, func(t *template.Template) (*template.Template, error) {
+			return t.AddParseTree(name, t.Tree.Copy())
+		})
 	}
 }
 
@@ -30,19 +153,50 @@ func WithTarget(target ...string) RenderOption {
 	}
 }
 
+// WithStreaming executes the Template directly into the writer passed to Render
+// instead of buffering the output until the whole Template has been executed.
+// If the writer implements http.Flusher, it is flushed after each target and
+// wherever the {{ flush }} function is called in the Template.
+//
+// Unlike the default behavior, the writer may receive partial output if an
+// error occurs while executing the Template.
+func WithStreaming() RenderOption {
+	return func(p *RenderProcess) {
+		p.Stream = true
+	}
+}
+
 // WithFuncs appends the given Template.FuncMap to the Template's internal
 // func map. These functions become available in the Template during execution
+//
+// Because functions are often closures over the data of a single request, the
+// Template is cloned on every call of Render. Use WithCachedFuncs for functions
+// that do not change between calls.
 func WithFuncs(funcs template.FuncMap) RenderOption {
 	return func(p *RenderProcess) {
-		p.Template = p.Template.Funcs(funcs)
+		p.Mutate("", func(t *template.Template) (*template.Template, error) {
+			return t.Funcs(funcs), nil
+		})
+	}
+}
+
+// WithCachedFuncs is like WithFuncs, but the Template with the given functions
+// is cached under the given key. Every call with the same key must pass
+// functions that behave the same.
+func WithCachedFuncs(key string, funcs template.FuncMap) RenderOption {
+	return func(p *RenderProcess) {
+		p.Mutate("funcs:"+key, func(t *template.Template) (*template.Template, error) {
+			return t.Funcs(funcs), nil
+		})
 	}
 }
 
 func (tmpl *managedTemplate[T]) Render(wr io.Writer, data T, opts ...RenderOption) error {
-	tmpl.mu.RLock()
-	t, err := tmpl.template.Clone()
-	tmpl.mu.RUnlock()
-	if err != nil {
+	return tmpl.RenderContext(context.Background(), wr, data, opts...)
+}
+
+func (tmpl *managedTemplate[T]) RenderContext(ctx context.Context, wr io.Writer, data T, opts ...RenderOption) (err error) {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
@@ -61,23 +215,95 @@ func (tmpl *managedTemplate[T]) Render(wr io.Writer, data T, opts ...RenderOptio
 		}
 	}()
 
+	tmpl.mu.RLock()
 	p := &RenderProcess{
-		Template: t,
-		Targets:  []string{},
+		Targets: []string{},
 	}
+	calls := tmpl.calls
+	entry := tmpl.entry
+	tmpl.mu.RUnlock()
 
 	for _, opt := range opts {
 		opt(p)
 	}
 
-	// render the default template if no targets are provided.
+	// check that the targets can be executed with the data paired with them
+	for target, d := range p.targetData {
+		if err := tmpl.checkTargetData(target, d); err != nil {
+			return err
+		}
+	}
+
+	// dataOf returns the data the given target is executed with
+	dataOf := func(target string) (interface{}, error) {
+		if d, ok := p.targetData[target]; ok {
+			return d.resolve(data)
+		}
+		return data, nil
+	}
+
+	flush := func() {}
+	if f, ok := wr.(http.Flusher); ok && p.Stream {
+		flush = f.Flush
+	}
+
+	// the writer the template is executed into fails once the context is done
+	withContext := func(wr io.Writer) io.Writer {
+		if ctx.Done() == nil {
+			return wr
+		}
+		return &contextWriter{ctx: ctx, wr: wr}
+	}
+
+	// the functions bound to the arguments of this call are only used if
+	// the template calls them, since that requires a clone of its own
+	var t *template.Template
+	if (p.Stream && calls[flushFuncName]) || (calls[contextFuncName] && ctx != context.Background()) {
+		b, release, err := tmpl.boundVariant(p.mutations)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		b.ctx, b.flush = ctx, flush
+		t = b.template
+	} else {
+		t, err = tmpl.variant(p.mutations)
+		if err != nil {
+			return err
+		}
+	}
+
+	// render the default template if no targets are provided. That is
+	// the outermost layout of the page if it has any.
 	if len(p.Targets) == 0 {
-		p.Targets = append(p.Targets, t.Tree.ParseName)
+		p.Targets = append(p.Targets, entry)
+	}
+
+	if p.Stream {
+		out := withContext(wr)
+		for _, target := range p.Targets {
+			d, err := dataOf(target)
+			if err != nil {
+				return err
+			}
+			if err := t.ExecuteTemplate(out, target, d); err != nil {
+				return err
+			}
+			flush()
+		}
+
+		return nil
 	}
 
 	buf := bytes.Buffer{}
+	out := withContext(&buf)
 	for _, target := range p.Targets {
-		if err := p.Template.ExecuteTemplate(&buf, target, data); err != nil {
+		d, err := dataOf(target)
+		if err != nil {
+			return err
+		}
+		if err := t.ExecuteTemplate(out, target, d); err != nil {
 			return err
 		}
 	}
@@ -104,3 +330,20 @@ func (tmpl *managedTemplate[T]) RenderToString(data T, opts ...RenderOption) (st
 	}
 	return buf.String(), nil
 }
+
+// callsFunc reports whether any of the templates associated with t call the
+// function with the given name.
+func callsFunc(t *template.Template, name string) bool {
+	found := false
+	for _, tt := range t.Templates() {
+		if tt.Tree == nil || tt.Tree.Root == nil {
+			continue
+		}
+		Traverse(tt.Tree.Root, func(node parse.Node) {
+			if ident, ok := node.(*parse.IdentifierNode); ok && ident.Ident == name {
+				found = true
+			}
+		})
+	}
+	return found
+}
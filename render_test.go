@@ -0,0 +1,401 @@
+package tmpl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type streamedPage struct {
+	Title string
+	Fail  bool
+}
+
+func (*streamedPage) TemplateText() string {
+	return `<head>{{ .Title }}</head>{{ flush }}<body>{{ .Body }}</body>`
+}
+
+func (p *streamedPage) Body() (string, error) {
+	if p.Fail {
+		return "", errors.New("failed to render body")
+	}
+	return "Hello World", nil
+}
+
+func Test_RenderStreaming(t *testing.T) {
+	testTable := []struct {
+		name        string
+		data        *streamedPage
+		opts        []RenderOption
+		wantOutput  string
+		wantFlushed bool
+		wantErr     bool
+	}{
+		{
+			name:        "Streams the output to the writer and flushes it",
+			data:        &streamedPage{Title: "Streamed"},
+			opts:        []RenderOption{WithStreaming()},
+			wantOutput:  "<head>Streamed</head><body>Hello World</body>",
+			wantFlushed: true,
+		},
+		{
+			name:        "Writes partial output when streaming fails",
+			data:        &streamedPage{Title: "Streamed", Fail: true},
+			opts:        []RenderOption{WithStreaming()},
+			wantOutput:  "<head>Streamed</head><body>",
+			wantFlushed: true,
+			wantErr:     true,
+		},
+		{
+			name:       "Writes nothing when buffered rendering fails",
+			data:       &streamedPage{Title: "Buffered", Fail: true},
+			wantOutput: "",
+			wantErr:    true,
+		},
+		{
+			name:       "Ignores flush calls when not streaming",
+			data:       &streamedPage{Title: "Buffered"},
+			wantOutput: "<head>Buffered</head><body>Hello World</body>",
+		},
+	}
+
+	tmpl, err := Compile(&streamedPage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			err := tmpl.Render(rec, tt.data, tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Render() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got := rec.Body.String(); got != tt.wantOutput {
+				t.Errorf("Render() output = %q, want %q", got, tt.wantOutput)
+			}
+			if rec.Flushed != tt.wantFlushed {
+				t.Errorf("Render() flushed = %v, want %v", rec.Flushed, tt.wantFlushed)
+			}
+		})
+	}
+}
+
+type contextPage struct {
+	cancel context.CancelFunc
+}
+
+func (*contextPage) TemplateText() string {
+	return `<p>{{ ctx "userID" }}</p><p>{{ greet ctx }}</p>{{ .Stop }}<p>done</p>`
+}
+
+func (p *contextPage) Stop() string {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	return ""
+}
+
+func Test_RenderContext(t *testing.T) {
+	tmpl := MustCompile(&contextPage{}, UseFuncs(FuncMap{
+		"greet": func(ctx context.Context) string {
+			return fmt.Sprintf("Hello %v", ctx.Value(ContextKey("userID")))
+		},
+	}))
+
+	t.Run("Exposes the context to templates and functions", func(t *testing.T) {
+		ctx := context.WithValue(context.Background(), ContextKey("userID"), "user1")
+
+		buf := bytes.Buffer{}
+		if err := tmpl.RenderContext(ctx, &buf, &contextPage{}); err != nil {
+			t.Fatal(err)
+		}
+		if want := "<p>user1</p><p>Hello user1</p><p>done</p>"; buf.String() != want {
+			t.Errorf("RenderContext() output = %q, want %q", buf.String(), want)
+		}
+	})
+
+	t.Run("Binds the context of each call concurrently", func(t *testing.T) {
+		wg := sync.WaitGroup{}
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				userID := fmt.Sprintf("user%d", i)
+				ctx := context.WithValue(context.Background(), ContextKey("userID"), userID)
+
+				for j := 0; j < 16; j++ {
+					buf := bytes.Buffer{}
+					if err := tmpl.RenderContext(ctx, &buf, &contextPage{}); err != nil {
+						t.Error(err)
+						return
+					}
+					if want := fmt.Sprintf("<p>%[1]s</p><p>Hello %[1]s</p><p>done</p>", userID); buf.String() != want {
+						t.Errorf("RenderContext() output = %q, want %q", buf.String(), want)
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		if got := tmpl.(*managedTemplate[*contextPage]).bound.len(); got != 1 {
+			t.Errorf("RenderContext() pooled %d bound variants, want 1", got)
+		}
+	})
+
+	t.Run("Does not render with a cancelled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		buf := bytes.Buffer{}
+		if err := tmpl.RenderContext(ctx, &buf, &contextPage{}); !errors.Is(err, context.Canceled) {
+			t.Fatalf("RenderContext() error = %v, want %v", err, context.Canceled)
+		}
+	})
+
+	for _, stream := range []bool{false, true} {
+		t.Run(fmt.Sprintf("Aborts rendering when the context is cancelled (stream=%v)", stream), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			opts := make([]RenderOption, 0)
+			if stream {
+				opts = append(opts, WithStreaming())
+			}
+
+			buf := bytes.Buffer{}
+			err := tmpl.RenderContext(ctx, &buf, &contextPage{cancel: cancel}, opts...)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("RenderContext() error = %v, want %v", err, context.Canceled)
+			}
+			if strings.Contains(buf.String(), "done") {
+				t.Errorf("RenderContext() output = %q, want it to be aborted", buf.String())
+			}
+		})
+	}
+}
+
+type cartView struct {
+	Count int
+}
+
+func (*cartView) TemplateText() string {
+	return `<span id="cart">{{ .Count }}</span>`
+}
+
+type fragmentPage struct {
+	Cart          cartView `tmpl:"cart"`
+	Notifications []string
+	Title         string
+}
+
+func (*fragmentPage) TemplateText() string {
+	return `{{ define "notifications" }}<ul>{{ range . }}<li>{{ . }}</li>{{ end }}</ul>{{ end }}<main>{{ .Title }}</main>`
+}
+
+func Test_RenderTargetData(t *testing.T) {
+	testTable := []struct {
+		name       string
+		opts       []RenderOption
+		wantOutput string
+		wantErr    string
+	}{
+		{
+			name: "Renders targets with the fields at their paths",
+			opts: []RenderOption{
+				WithTarget("*tmpl.fragmentPage"),
+				WithTargetField("cart", ".Cart"),
+				WithTargetField("notifications", ".Notifications"),
+			},
+			wantOutput: `<main>Fragments</main><span id="cart">2</span><ul><li>Added to cart</li></ul>`,
+		},
+		{
+			name:       "Renders targets with the given data",
+			opts:       []RenderOption{WithTargetData("cart", &cartView{Count: 5})},
+			wantOutput: `<span id="cart">5</span>`,
+		},
+		{
+			name:    "Fails to render targets with fields of the wrong type",
+			opts:    []RenderOption{WithTargetField("cart", ".Title")},
+			wantErr: `template "cart" expects data of type tmpl.cartView: got string`,
+		},
+		{
+			name:    "Fails to render targets with undefined fields",
+			opts:    []RenderOption{WithTargetField("cart", ".Crat")},
+			wantErr: `target "cart": field ".Crat" not defined in type tmpl.fragmentPage`,
+		},
+		{
+			name:    "Fails to render targets with data the template cannot be executed with",
+			opts:    []RenderOption{WithTargetData("notifications", &cartView{})},
+			wantErr: `tmpl.fragmentPage:1:42: range can't iterate over`,
+		},
+	}
+
+	tmpl := MustCompile(&fragmentPage{})
+
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tmpl.RenderToString(&fragmentPage{
+				Cart:          cartView{Count: 2},
+				Notifications: []string{"Added to cart"},
+				Title:         "Fragments",
+			}, tt.opts...)
+			if len(tt.wantErr) != 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Render() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.wantOutput {
+				t.Errorf("Render() output = %q, want %q", got, tt.wantOutput)
+			}
+		})
+	}
+}
+
+func Test_RenderVariants(t *testing.T) {
+	manyNames := make([][]RenderOption, 0, variantCacheSize+8)
+	for i := 0; i < variantCacheSize+8; i++ {
+		manyNames = append(manyNames, []RenderOption{WithName(fmt.Sprintf("page%d", i))})
+	}
+
+	testTable := []struct {
+		name         string
+		opts         [][]RenderOption
+		wantVariants int
+	}{
+		{
+			name:         "Does not clone the template without options",
+			opts:         [][]RenderOption{{}, {WithTarget("*tmpl.streamedPage")}},
+			wantVariants: 1,
+		},
+		{
+			name:         "Caches the template for each name",
+			opts:         [][]RenderOption{{WithName("a")}, {WithName("a")}, {WithName("b")}},
+			wantVariants: 3,
+		},
+		{
+			name: "Caches the template for each key of cached funcs",
+			opts: [][]RenderOption{
+				{WithCachedFuncs("upper", template.FuncMap{"upper": strings.ToUpper})},
+				{WithCachedFuncs("upper", template.FuncMap{"upper": strings.ToUpper})},
+				{WithName("a"), WithCachedFuncs("upper", template.FuncMap{"upper": strings.ToUpper})},
+			},
+			wantVariants: 3,
+		},
+		{
+			name:         "Does not cache the template with funcs",
+			opts:         [][]RenderOption{{WithFuncs(template.FuncMap{"upper": strings.ToUpper})}, {WithName("a"), WithFuncs(template.FuncMap{"upper": strings.ToUpper})}},
+			wantVariants: 1,
+		},
+		{
+			name:         "Drops the templates used least recently once the cache is full",
+			opts:         manyNames,
+			wantVariants: variantCacheSize,
+		},
+	}
+
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := Compile(&streamedPage{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, opts := range tt.opts {
+				if _, err := tmpl.RenderToString(&streamedPage{Title: "Variants"}, opts...); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got := tmpl.(*managedTemplate[*streamedPage]).variants.len(); got != tt.wantVariants {
+				t.Errorf("Render() cached %d variants, want %d", got, tt.wantVariants)
+			}
+		})
+	}
+}
+
+func Test_RenderConcurrently(t *testing.T) {
+	tmpl := MustCompile(&streamedPage{})
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("page%d", i%2)
+			if _, err := tmpl.RenderToString(&streamedPage{Title: name}, WithName(name)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func BenchmarkRender(b *testing.B) {
+	tmpl := MustCompile(&streamedPage{})
+	data := &streamedPage{Title: "Benchmark"}
+
+	benchmarks := []struct {
+		name string
+		opts []RenderOption
+	}{
+		{name: "NoOptions"},
+		{name: "WithTarget", opts: []RenderOption{WithTarget("*tmpl.streamedPage")}},
+		{name: "WithName", opts: []RenderOption{WithName("benchmark")}},
+		{name: "WithCachedFuncs", opts: []RenderOption{WithCachedFuncs("upper", template.FuncMap{"upper": strings.ToUpper})}},
+		{name: "WithFuncs", opts: []RenderOption{WithFuncs(template.FuncMap{"upper": strings.ToUpper})}},
+		{name: "WithStreaming", opts: []RenderOption{WithStreaming()}},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := tmpl.Render(io.Discard, data, bm.opts...); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+
+	// flush is bound to the writer of each call when streaming
+	b.Run("WithStreamingFlush", func(b *testing.B) {
+		b.ReportAllocs()
+		w := httptest.NewRecorder()
+		for i := 0; i < b.N; i++ {
+			w.Body.Reset()
+			if err := tmpl.Render(w, data, WithStreaming()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	// ctx is bound to the context of each call
+	b.Run("RenderContext", func(b *testing.B) {
+		tmpl := MustCompile(&contextPage{}, UseFuncs(FuncMap{
+			"greet": func(ctx context.Context) string { return "Hello" },
+		}))
+		ctx := context.WithValue(context.Background(), ContextKey("userID"), "user")
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := tmpl.RenderContext(ctx, io.Discard, &contextPage{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
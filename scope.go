@@ -0,0 +1,68 @@
+package tmpl
+
+// scope is a lexical scope within a template body used during static type
+// checking. A variable's scope extends to the {{ end }} action of the control
+// structure it is declared in, or to the end of the template if it is not
+// declared within a control structure.
+type scope struct {
+	parent *scope
+
+	// dot is the FieldNode that dot ({{ . }}) refers to in this scope.
+	// It is nil if the type of dot cannot be inferred.
+	dot *FieldNode
+	// path is the path of dot relative to the root of the field tree. It is
+	// used to give errors about fields of dot some context.
+	path string
+	// vars are the variables declared in this scope. A nil FieldNode means
+	// the type of the variable cannot be inferred.
+	vars map[string]*FieldNode
+}
+
+// newScope creates the top level scope of a template body where both dot
+// and the $ variable refer to the given FieldNode.
+func newScope(root *FieldNode) *scope {
+	return &scope{
+		dot:  root,
+		vars: map[string]*FieldNode{"$": root},
+	}
+}
+
+// child creates a new scope nested in this one in which dot refers to the
+// given FieldNode.
+func (s *scope) child(dot *FieldNode, path string) *scope {
+	return &scope{
+		parent: s,
+		dot:    dot,
+		path:   path,
+		vars:   make(map[string]*FieldNode),
+	}
+}
+
+// lookup finds the variable with the given name in this scope or any of
+// its parents. The returned bool is false if the variable is not declared.
+func (s *scope) lookup(name string) (*FieldNode, bool) {
+	for cur := s; cur != nil; cur = cur.parent {
+		if node, ok := cur.vars[name]; ok {
+			return node, true
+		}
+	}
+	return nil, false
+}
+
+// declare adds a variable to this scope, shadowing any variable with the
+// same name declared in a parent scope.
+func (s *scope) declare(name string, node *FieldNode) {
+	s.vars[name] = node
+}
+
+// assign changes the type of an already declared variable. It returns false
+// if the variable is not declared in this scope or any of its parents.
+func (s *scope) assign(name string, node *FieldNode) bool {
+	for cur := s; cur != nil; cur = cur.parent {
+		if _, ok := cur.vars[name]; ok {
+			cur.vars[name] = node
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package tmpl
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// slotTagOption is the option of the tmpl struct tag that marks a nested
+// TemplateProvider as the override of the block of the same name declared by
+// another template:
+//
+//	type LoginPage struct {
+//		AppLayout    `tmpl:"app,layout"`
+//		LoginScripts `tmpl:"scripts,slot"`
+//	}
+const slotTagOption = "slot"
+
+// SlotProvider is an optional interface that can be implemented by any
+// TemplateProvider to override the blocks declared by the templates nested in
+// it. TemplateSlots returns the template text of each overridden block by the
+// name of the block:
+//
+//	func (*LoginPage) TemplateSlots() map[string]string {
+//		return map[string]string{"scripts": `<script src="/login.js"></script>`}
+//	}
+type SlotProvider interface {
+	TemplateSlots() map[string]string
+}
+
+// slot is the override of a block.
+type slot struct {
+	// name is the name of the overridden block
+	name string
+	// text is the template text the block is overridden with
+	text string
+	// source is the file path the text was loaded from, if known
+	source string
+}
+
+// isSlotField reports whether the given field is tagged as a slot.
+func isSlotField(field reflect.StructField) bool {
+	return hasTemplateTagOption(field, slotTagOption)
+}
+
+// slotFilter returns a function that reports whether a TemplateProvider visited
+// by recurseFieldsImplementing is a slot. Each slot is visited twice: once as
+// the tagged field and once as the root of its own nested templates, which is
+// passed a StructField without a type.
+func slotFilter() func(tp TemplateProvider, field reflect.StructField) bool {
+	slots := make(map[reflect.Type]bool)
+	return func(tp TemplateProvider, field reflect.StructField) bool {
+		if isSlotField(field) {
+			slots[reflect.TypeOf(tp)] = true
+			return true
+		}
+		return field.Type == nil && slots[reflect.TypeOf(tp)]
+	}
+}
+
+// collectSlots returns the overrides of blocks of the given TemplateProvider and
+// all of its nested templates. If a block is overridden more than once, the
+// override found first, starting at the given TemplateProvider, is used.
+func collectSlots(tp TemplateProvider) ([]slot, error) {
+	slots := make([]slot, 0)
+	seen := make(map[string]bool)
+
+	add := func(s slot) {
+		if !seen[s.name] {
+			seen[s.name] = true
+			slots = append(slots, s)
+		}
+	}
+
+	err := recurseFieldsImplementing[TemplateProvider](tp, func(tp TemplateProvider, field reflect.StructField) error {
+		if isSlotField(field) {
+			s := slot{
+				name: templateNameOf(field, field.Name),
+				text: tp.TemplateText(),
+			}
+			if sp, ok := tp.(TemplateSourceProvider); ok {
+				s.source = sp.TemplateSource()
+			}
+			add(s)
+		}
+
+		if sp, ok := tp.(SlotProvider); ok {
+			texts := sp.TemplateSlots()
+			names := make([]string, 0, len(texts))
+			for name := range texts {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			for _, name := range names {
+				if len(strings.TrimSpace(name)) == 0 {
+					return fmt.Errorf("%T returned a slot without a name", tp)
+				}
+				add(slot{name: name, text: texts[name]})
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return slots, nil
+}
+
+// slotText returns the text the block of the given slot is overridden with.
+// Go templates ignore the redefinition of a template with an empty body, so an
+// empty slot is replaced by an empty action.
+func slotText(s slot, opts ParseOptions) string {
+	if len(strings.TrimSpace(s.text)) == 0 {
+		return fmt.Sprintf("%s\"\"%s", opts.LeftDelim, opts.RightDelim)
+	}
+	return s.text
+}
@@ -0,0 +1,226 @@
+package tmpl
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// targetData is the data a target is executed with instead of the data
+// passed to Render.
+type targetData struct {
+	// path is the path of the field of the data passed to Render, or nil
+	// if value is used instead
+	path []string
+	// value is the data the target is executed with
+	value interface{}
+}
+
+// WithTargetField adds the given target, like WithTarget, and executes it with
+// the field at the given path of the data passed to Render instead of the data
+// itself. The path is written like in a template: ".Cart" or ".Cart.Summary".
+// This allows rendering several fragments with their own data in one call,
+// such as the out-of-band swaps of htmx:
+//
+//	tmpl.Render(w, page, WithTarget("main"), WithTargetField("cart", ".Cart"))
+//
+// The pairing of the target and the field is checked by the static type
+// checker the first time it is rendered, and Render fails if it is invalid.
+func WithTargetField(target string, path string) RenderOption {
+	return func(p *RenderProcess) {
+		p.Targets = append(p.Targets, target)
+		p.setTargetData(target, targetData{path: splitPath(path)})
+	}
+}
+
+// WithTargetData adds the given target, like WithTarget, and executes it with
+// the given data instead of the data passed to Render. The pairing of the
+// target and the type of the data is checked by the static type checker the
+// first time it is rendered, and Render fails if it is invalid.
+func WithTargetData(target string, data interface{}) RenderOption {
+	return func(p *RenderProcess) {
+		p.Targets = append(p.Targets, target)
+		p.setTargetData(target, targetData{value: data})
+	}
+}
+
+func (p *RenderProcess) setTargetData(target string, data targetData) {
+	if p.targetData == nil {
+		p.targetData = make(map[string]targetData)
+	}
+	p.targetData[target] = data
+}
+
+// splitPath splits a path of fields written like in a template.
+func splitPath(path string) []string {
+	path = strings.TrimPrefix(path, ".")
+	if len(path) == 0 {
+		return []string{}
+	}
+	return strings.Split(path, ".")
+}
+
+// key identifies the pairing of the given target and this data for caching
+// the result of checking it.
+func (d targetData) key(target string) string {
+	if d.path != nil {
+		return fmt.Sprintf("%s\x00path:%s", target, strings.Join(d.path, "."))
+	}
+	return fmt.Sprintf("%s\x00type:%s", target, reflect.TypeOf(d.value))
+}
+
+// resolve returns the data the target is executed with, given the data passed
+// to Render.
+func (d targetData) resolve(data interface{}) (interface{}, error) {
+	if d.path == nil {
+		return d.value, nil
+	}
+
+	val, err := resolvePath(reflect.ValueOf(data), d.path)
+	if err != nil {
+		return nil, err
+	}
+	if !val.IsValid() {
+		return nil, nil
+	}
+	return val.Interface(), nil
+}
+
+// resolvePath returns the value at the given path of fields, methods and map
+// keys, evaluated the same way as a field chain in a template.
+func resolvePath(val reflect.Value, path []string) (reflect.Value, error) {
+	for i, name := range path {
+		evaluated := "." + strings.Join(path[:i+1], ".")
+
+		// like in a template, methods of the pointer are also considered
+		ptr := val
+		if ptr.Kind() != reflect.Interface && ptr.Kind() != reflect.Ptr && ptr.CanAddr() {
+			ptr = ptr.Addr()
+		}
+		if method := ptr.MethodByName(name); method.IsValid() {
+			if method.Type().NumIn() != 0 {
+				return reflect.Value{}, fmt.Errorf("%s is a method with arguments", evaluated)
+			}
+			out := method.Call(nil)
+			if len(out) == 0 {
+				return reflect.Value{}, fmt.Errorf("%s is a method without results", evaluated)
+			}
+			if len(out) == 2 && !out[1].IsNil() {
+				return reflect.Value{}, fmt.Errorf("error calling %s: %w", evaluated, out[1].Interface().(error))
+			}
+			val = out[0]
+			continue
+		}
+
+		for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+			if val.IsNil() {
+				return reflect.Value{}, fmt.Errorf("nil pointer evaluating %s", evaluated)
+			}
+			val = val.Elem()
+		}
+
+		switch val.Kind() {
+		case reflect.Struct:
+			field := val.FieldByName(name)
+			if !field.IsValid() || !field.CanInterface() {
+				return reflect.Value{}, fmt.Errorf("can't evaluate %s: field %q not defined in type %s", evaluated, name, val.Type())
+			}
+			val = field
+		case reflect.Map:
+			key := reflect.ValueOf(name)
+			if !key.Type().ConvertibleTo(val.Type().Key()) {
+				return reflect.Value{}, fmt.Errorf("can't evaluate %s: map of type %s has no string keys", evaluated, val.Type())
+			}
+			elem := val.MapIndex(key.Convert(val.Type().Key()))
+			if !elem.IsValid() {
+				elem = reflect.Zero(val.Type().Elem())
+			}
+			val = elem
+		default:
+			return reflect.Value{}, fmt.Errorf("can't evaluate %s in type %s", evaluated, val.Type())
+		}
+	}
+
+	return val, nil
+}
+
+// checkTargetData uses the static type checker to check that the given target
+// can be executed with the given data. The result is cached for each target
+// and type or path of the data.
+func (tmpl *managedTemplate[T]) checkTargetData(target string, data targetData) error {
+	key := data.key(target)
+
+	tmpl.mu.RLock()
+	err, ok := tmpl.checkedTargets[key]
+	tmpl.mu.RUnlock()
+	if ok {
+		return err
+	}
+
+	tmpl.mu.Lock()
+	defer tmpl.mu.Unlock()
+	if tmpl.helper == nil {
+		return nil
+	}
+
+	var typ *FieldNode
+	if data.path != nil {
+		typ = tmpl.helper.GetDefinedField(strings.Join(data.path, "."))
+		if typ == nil {
+			err = fmt.Errorf("target %q: field %q not defined in type %s", target, "."+strings.Join(data.path, "."), indirectType(tmpl.helper.fieldTree.Type()))
+		}
+	} else if data.value == nil {
+		err = fmt.Errorf("target %q: data must not be nil", target)
+	} else if t := reflect.TypeOf(data.value); indirectType(t).Kind() == reflect.Struct {
+		typ, err = createFieldTreeFromType(t.String(), t)
+	} else {
+		typ = createTypeNode(t.String(), t)
+	}
+
+	if err == nil {
+		err = tmpl.helper.checkTemplateData(target, typ)
+	}
+
+	tmpl.checkedTargets[key] = err
+	return err
+}
+
+// checkTemplateData runs the static type checker on the template with the
+// given name as if it was executed with data of the given FieldNode's type. It
+// returns an *AnalysisError if any errors were found.
+func (h *AnalysisHelper) checkTemplateData(name string, typ *FieldNode) error {
+	// the template of the analysis target is named after its pointer type
+	// when compiled, but not when analyzed
+	name = strings.TrimPrefix(name, "*")
+
+	tree, ok := h.treeSet[name]
+	if !ok || tree.Root == nil {
+		return fmt.Errorf("template %q is not defined", name)
+	}
+	if provider, ok := h.providers[name]; ok && typ.Type() != nil && !embedsType(typ.Type(), provider) {
+		return &AnalysisError{Diagnostics: []Diagnostic{{
+			Severity: SeverityError,
+			Code:     CodeInvalidTemplateData,
+			Template: name,
+			Message:  fmt.Sprintf("template %q expects data of type %s: got %s", name, indirectType(provider), typ.Type()),
+		}}}
+	}
+
+	prevTree, prevFieldTree, prevDiagnostics, prevCtx := h.tree, h.fieldTree, h.diagnostics, h.ctx
+	defer func() {
+		h.tree, h.fieldTree, h.diagnostics, h.ctx = prevTree, prevFieldTree, prevDiagnostics, prevCtx
+	}()
+	h.tree, h.fieldTree, h.diagnostics, h.ctx = tree, typ, make([]Diagnostic, 0), context.Background()
+
+	val := typ.Value
+	if !val.IsValid() && typ.Type() != nil {
+		val = reflect.New(indirectType(typ.Type()))
+	}
+	staticTypingRecursive(newScope(typ), val, tree.Root, h)
+
+	if h.HasErrors() {
+		return &AnalysisError{Diagnostics: h.Diagnostics()}
+	}
+	return nil
+}
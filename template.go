@@ -1,8 +1,11 @@
 package tmpl
 
 import (
+	"context"
 	"html/template"
 	"io"
+	"sort"
+	"strings"
 	"sync"
 )
 
@@ -11,19 +14,228 @@ type TemplateProvider interface {
 	TemplateText() string
 }
 
+// TemplateSourceProvider is an optional interface that can be implemented by any
+// TemplateProvider to report the path of the file its template text was loaded
+// from. When implemented, analysis diagnostics refer to the file path.
+type TemplateSourceProvider interface {
+	TemplateSource() string
+}
+
+// TemplateWatcher is an optional interface that can be implemented by any
+// TemplateProvider. The returned channel is used to signal that the template
+// text has changed and that the owning Template should be recompiled. Closing
+// the channel stops the Template from watching it.
+//
+// Watch is called once for each TemplateProvider in the tree. A Watch method
+// promoted from an embedded TemplateProvider is only called on the embedded
+// field, so a struct embedding a TemplateWatcher cannot add its own signals.
+type TemplateWatcher interface {
+	Watch() <-chan struct{}
+}
+
+// TemplateUnwatcher is an optional interface that can be implemented by any
+// TemplateWatcher. Unwatch is called with each channel returned by Watch once
+// the Template stops watching it because the context given by UseContext is
+// cancelled, so that the watcher can release the channel.
+type TemplateUnwatcher interface {
+	Unwatch(ch <-chan struct{})
+}
+
 type Template[T TemplateProvider] interface {
 	// Render can be used to execute the internal template.
 	Render(w io.Writer, data T, opts ...RenderOption) error
+	// RenderContext is like Render, but aborts the execution of the template
+	// when the given context is done. The context is available in the
+	// template via the ctx function.
+	RenderContext(ctx context.Context, w io.Writer, data T, opts ...RenderOption) error
 	// RenderToChan can be used to execute the internal template and write the result to a channel.
 	RenderToChan(ch chan string, data T, opts ...RenderOption) error
 	// RenderToString can be used to execute the internal template and return the result as a string.
 	RenderToString(data T, opts ...RenderOption) (string, error)
+	// Targets returns the sorted names of all templates that can be passed
+	// to WithTarget, including nested templates and {{ define }} blocks.
+	// The name of the TemplateProvider's type renders it without its layouts.
+	Targets() []string
 }
 
 // managedTemplate represents a loaded and compiled tmpl file
 type managedTemplate[T TemplateProvider] struct {
 	// mu is the mutex used to write to the underlying template
 	mu *sync.RWMutex
-	// template is the compiled Go template
+	// template is the compiled Go template. It is never executed, because
+	// html/template does not allow cloning a template after execution.
 	template *template.Template
+	// entry is the name of the template rendered if no target is given
+	entry string
+	// targets are the sorted names of all templates of template
+	targets []string
+	// helper holds the results of the analysis of the template, which are
+	// used to check the data passed to targets. It is not safe for
+	// concurrent use and must only be used while holding mu for writing.
+	helper *AnalysisHelper
+	// checkedTargets caches the results of checking the data passed to
+	// targets, keyed by the target and the type or path of the data
+	checkedTargets map[string]error
+	// variants are the clones of template that are executed by Render, keyed
+	// by the mutations of the RenderOptions applied to them. The clone
+	// without any mutations is stored under the empty key.
+	variants *lruCache[*template.Template]
+	// bound are the clones of template with the functions bound to each call
+	// of RenderContext, keyed like variants. A clone is used by one call at
+	// a time and put back into its pool once the call is done.
+	bound *lruCache[*sync.Pool]
+	// calls records which of the functions bound to each call of
+	// RenderContext are called by the template
+	calls map[string]bool
+}
+
+// setTemplate replaces the compiled template and drops all cached variants.
+func (tmpl *managedTemplate[T]) setTemplate(compiled *compiledTemplate) error {
+	t := compiled.template
+	base, err := t.Clone()
+	if err != nil {
+		return err
+	}
+
+	tmpl.mu.Lock()
+	defer tmpl.mu.Unlock()
+	tmpl.template = t
+	tmpl.entry = compiled.entry
+	tmpl.targets = compiled.targets
+	tmpl.helper = compiled.helper
+	tmpl.checkedTargets = make(map[string]error)
+	tmpl.variants = newLRUCache[*template.Template](variantCacheSize)
+	tmpl.variants.add("", base)
+	tmpl.bound = newLRUCache[*sync.Pool](variantCacheSize)
+	tmpl.calls = make(map[string]bool, len(boundFuncNames))
+	for _, name := range boundFuncNames {
+		tmpl.calls[name] = callsFunc(t, name)
+	}
+	return nil
+}
+
+// variant returns a clone of the compiled template with the given mutations
+// applied. Clones are cached by the keys of the mutations, unless any of the
+// mutations has an empty key. Only the variantCacheSize clones used most
+// recently are kept.
+func (tmpl *managedTemplate[T]) variant(mutations []mutation) (*template.Template, error) {
+	key, cacheable := variantKey(mutations)
+
+	// the caches are replaced along with the template when it is
+	// recompiled, so clones of an old template are never cached
+	tmpl.mu.RLock()
+	t, variants := tmpl.template, tmpl.variants
+	tmpl.mu.RUnlock()
+	if cacheable {
+		if cached, ok := variants.get(key); ok {
+			return cached, nil
+		}
+	}
+
+	clone, err := mutate(t, mutations)
+	if err != nil {
+		return nil, err
+	}
+
+	if cacheable {
+		clone = variants.add(key, clone)
+	}
+
+	return clone, nil
+}
+
+// boundVariant is like variant, but the returned clone also has the functions
+// bound to each call of RenderContext, which call the fields of the returned
+// boundTemplate. Clones are reused by calls that do not run at the same time,
+// so the template is not cloned on every call. The returned function must be
+// called once the clone has been executed.
+func (tmpl *managedTemplate[T]) boundVariant(mutations []mutation) (*boundTemplate, func(), error) {
+	key, cacheable := variantKey(mutations)
+
+	tmpl.mu.RLock()
+	t, bound := tmpl.template, tmpl.bound
+	tmpl.mu.RUnlock()
+
+	var pool *sync.Pool
+	if cacheable {
+		var ok bool
+		if pool, ok = bound.get(key); !ok {
+			pool = bound.add(key, &sync.Pool{})
+		}
+	}
+
+	release := func(b *boundTemplate) func() {
+		if pool == nil {
+			return func() {}
+		}
+		return func() {
+			b.reset()
+			pool.Put(b)
+		}
+	}
+
+	if pool != nil {
+		if b, ok := pool.Get().(*boundTemplate); ok {
+			return b, release(b), nil
+		}
+	}
+
+	clone, err := mutate(t, mutations)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b := &boundTemplate{}
+	b.reset()
+	b.template = clone.Funcs(b.funcs())
+	return b, release(b), nil
+}
+
+// variantKey returns the key the clone with the given mutations is cached
+// under, and whether it can be cached at all.
+func variantKey(mutations []mutation) (string, bool) {
+	keys := make([]string, 0, len(mutations))
+	cacheable := true
+	for _, m := range mutations {
+		keys = append(keys, m.key)
+		cacheable = cacheable && len(m.key) != 0
+	}
+	return strings.Join(keys, "\x00"), cacheable
+}
+
+// mutate returns a clone of t with the given mutations applied.
+func mutate(t *template.Template, mutations []mutation) (*template.Template, error) {
+	clone, err := t.Clone()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range mutations {
+		clone, err = m.fn(clone)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return clone, nil
+}
+
+func (tmpl *managedTemplate[T]) Targets() []string {
+	tmpl.mu.RLock()
+	defer tmpl.mu.RUnlock()
+
+	targets := make([]string, len(tmpl.targets))
+	copy(targets, tmpl.targets)
+	return targets
+}
+
+// templateNames returns the sorted names of all templates associated with t
+// that have a body.
+func templateNames(t *template.Template) []string {
+	names := make([]string, 0)
+	for _, tt := range t.Templates() {
+		if tt.Tree != nil && tt.Tree.Root != nil {
+			names = append(names, tt.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
 }
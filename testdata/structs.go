@@ -1,5 +1,11 @@
 package testdata
 
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
 type TextComponent struct {
 	Text string
 }
@@ -213,6 +219,185 @@ func (*OutletWithNestedLayout) TemplateText() string {
 	return `{{ .Content }}`
 }
 
+type RootLayout struct {
+	Title string
+}
+
+func (*RootLayout) TemplateText() string {
+	return `<html><head><title>{{ .Title }}</title>{{ outlet "head" }}</head><body>{{ outlet }}</body></html>`
+}
+
+type SectionLayout struct {
+	RootLayout `tmpl:"root,layout"`
+
+	Section string
+}
+
+func (*SectionLayout) TemplateText() string {
+	return `<main><h1>{{ .Section }}</h1>{{ outlet }}</main>{{ with .Section }}<aside>{{ outlet "sidebar" }}</aside>{{ end }}`
+}
+
+type LayoutPage struct {
+	SectionLayout `tmpl:"section,layout"`
+
+	Content string
+}
+
+func (*LayoutPage) TemplateText() string {
+	return `{{ define "sidebar" }}<nav>{{ .Section }}</nav>{{ end }}<p>{{ .Content }}</p>`
+}
+
+type OutletOutsideLayout struct {
+	Content string
+}
+
+func (*OutletOutsideLayout) TemplateText() string {
+	return `<p>{{ outlet }}</p>`
+}
+
+type PipedOutletLayout struct{}
+
+func (*PipedOutletLayout) TemplateText() string {
+	return `<body>{{ outlet | print }}</body>`
+}
+
+type PipedOutletPage struct {
+	PipedOutletLayout `tmpl:"piped,layout"`
+}
+
+func (*PipedOutletPage) TemplateText() string {
+	return `<p></p>`
+}
+
+type NamedLayoutField struct {
+	Layout RootLayout `tmpl:"root,layout"`
+}
+
+func (*NamedLayoutField) TemplateText() string {
+	return `<p></p>`
+}
+
+type SlotLayout struct {
+	Title string
+}
+
+func (*SlotLayout) TemplateText() string {
+	return `<head><title>{{ .Title }}</title>{{ block "scripts" . }}<script src="/app.js"></script>{{ end }}</head><body>{{ outlet }}</body>`
+}
+
+type DefaultSlotPage struct {
+	SlotLayout `tmpl:"slots,layout"`
+
+	Name string
+}
+
+func (*DefaultSlotPage) TemplateText() string {
+	return `<p>{{ .Name }}</p>`
+}
+
+type PageScripts struct{}
+
+func (*PageScripts) TemplateText() string {
+	return `<script src="/{{ .Name }}.js"></script>`
+}
+
+type TagSlotPage struct {
+	SlotLayout  `tmpl:"slots,layout"`
+	PageScripts `tmpl:"scripts,slot"`
+
+	Name string
+}
+
+func (*TagSlotPage) TemplateText() string {
+	return `<p>{{ .Name }}</p>`
+}
+
+type MethodSlotPage struct {
+	SlotLayout `tmpl:"slots,layout"`
+
+	Name string
+}
+
+func (*MethodSlotPage) TemplateText() string {
+	return `<p>{{ .Name }}</p>`
+}
+
+func (*MethodSlotPage) TemplateSlots() map[string]string {
+	return map[string]string{"scripts": `<meta name="page" content="{{ .Name }}">`}
+}
+
+type EmptySlotPage struct {
+	SlotLayout `tmpl:"slots,layout"`
+}
+
+func (*EmptySlotPage) TemplateText() string {
+	return `<p></p>`
+}
+
+func (*EmptySlotPage) TemplateSlots() map[string]string {
+	return map[string]string{"scripts": ""}
+}
+
+type UndeclaredSlotPage struct {
+	SlotLayout `tmpl:"slots,layout"`
+}
+
+func (*UndeclaredSlotPage) TemplateText() string {
+	return `<p></p>`
+}
+
+func (*UndeclaredSlotPage) TemplateSlots() map[string]string {
+	return map[string]string{"styles": `<link rel="stylesheet" href="/app.css">`}
+}
+
+type SlotWithTypo struct {
+	SlotLayout `tmpl:"slots,layout"`
+
+	Name string
+}
+
+func (*SlotWithTypo) TemplateText() string {
+	return `<p>{{ .Name }}</p>`
+}
+
+func (*SlotWithTypo) TemplateSlots() map[string]string {
+	return map[string]string{"scripts": `<meta content="{{ .Nmae }}">`}
+}
+
+type LoginForm struct {
+	User string
+}
+
+func (*LoginForm) TemplateText() string {
+	return `{{ define "form" }}<form>{{ .User }}</form>{{ end }}{{ template "form" . }}`
+}
+
+type SignupForm struct {
+	Email string
+}
+
+func (*SignupForm) TemplateText() string {
+	return `{{ define "form" }}<form>{{ .Email }}</form>{{ end }}{{ template "form" . }}`
+}
+
+type DuplicateDefines struct {
+	Login  LoginForm  `tmpl:"login"`
+	Signup SignupForm `tmpl:"signup"`
+}
+
+func (*DuplicateDefines) TemplateText() string {
+	return `{{ template "login" .Login }}{{ template "signup" .Signup }}`
+}
+
+type DuplicateTags struct {
+	Login  LoginForm  `tmpl:"account"`
+	Signup SignupForm `tmpl:"account"`
+}
+
+func (*DuplicateTags) TemplateText() string {
+	return `{{ template "account" .Login }}`
+}
+
 type IfWithinRange struct {
 	DefList []DefinedIf
 }
@@ -254,3 +439,477 @@ type DollarSignWithinIfWithinRange struct {
 func (*DollarSignWithinIfWithinRange) TemplateText() string {
 	return `{{ range .DefList }}{{ if eq . $.DefStr }}PASS{{else}}FAIL{{ end }}{{ end }}`
 }
+
+type SourcedUndefinedField struct{}
+
+func (*SourcedUndefinedField) TemplateText() string {
+	return "<div>\n  {{ .UndField }}\n</div>"
+}
+
+func (*SourcedUndefinedField) TemplateSource() string {
+	return "testdata/undefined.tmpl.html"
+}
+
+type DeclaredVariable struct {
+	Nested DefinedField
+}
+
+func (*DeclaredVariable) TemplateText() string {
+	return `{{ $x := .Nested }}{{ $x.DefField }}`
+}
+
+type AssignedVariable struct {
+	First  DefinedField
+	Second DefinedField
+}
+
+func (*AssignedVariable) TemplateText() string {
+	return `{{ $x := .First }}{{ $x = .Second }}{{ $x.DefField }}`
+}
+
+type VariableWithinIf struct {
+	DefIf  bool
+	Nested DefinedField
+}
+
+func (*VariableWithinIf) TemplateText() string {
+	return `{{ if $x := .DefIf }}{{ $y := $.Nested }}{{ if $x }}{{ $y.DefField }}{{ end }}{{ end }}`
+}
+
+type UndefinedVariableField struct {
+	Nested DefinedField
+}
+
+func (*UndefinedVariableField) TemplateText() string {
+	return `{{ $x := .Nested }}{{ $x.UndField }}`
+}
+
+type UndeclaredVariable struct {
+	DefIf bool
+}
+
+func (*UndeclaredVariable) TemplateText() string {
+	return `{{ if .DefIf }}{{ $x := .DefIf }}{{ end }}{{ $x }}`
+}
+
+type AssignedUndeclaredVariable struct{}
+
+func (*AssignedUndeclaredVariable) TemplateText() string {
+	return `{{ $x = 1 }}`
+}
+
+type RangeWithAssignments struct {
+	List []NamedStruct
+}
+
+func (*RangeWithAssignments) TemplateText() string {
+	return `{{ range $i, $v := .List }}{{ $i }}:{{ $v.DefField }} {{ end }}`
+}
+
+type RangeOverMap struct {
+	Map map[string]*NamedStruct
+}
+
+func (*RangeOverMap) TemplateText() string {
+	return `{{ range $k, $v := .Map }}{{ $k }}={{ $v.DefField }} {{ end }}`
+}
+
+type RangeOverChan struct {
+	Chan chan NamedStruct
+}
+
+func (*RangeOverChan) TemplateText() string {
+	return `{{ range $v := .Chan }}{{ $v.DefField }}{{ end }}`
+}
+
+type RangeOverInt struct {
+	Count int
+}
+
+func (*RangeOverInt) TemplateText() string {
+	return `{{ range $i := .Count }}{{ $i }}{{ end }}`
+}
+
+type RangeUndefinedElemField struct {
+	List []NamedStruct
+}
+
+func (*RangeUndefinedElemField) TemplateText() string {
+	return `{{ range $i, $v := .List }}{{ $v.UndField }}{{ end }}`
+}
+
+type RangeOverChanWithKey struct {
+	Chan chan NamedStruct
+}
+
+func (*RangeOverChanWithKey) TemplateText() string {
+	return `{{ range $i, $v := .Chan }}{{ end }}`
+}
+
+type RangeOverString struct {
+	DefStr string
+}
+
+func (*RangeOverString) TemplateText() string {
+	return `{{ range .DefStr }}{{ end }}`
+}
+
+type DefinedWith struct {
+	Nested  *NamedStruct
+	Message string
+}
+
+func (*DefinedWith) TemplateText() string {
+	return `{{ with .Nested }}{{ .DefField }}{{ else }}{{ .Message }}{{ end }}`
+}
+
+type DefinedElseWith struct {
+	First  *NamedStruct
+	Second *DefinedField
+}
+
+func (*DefinedElseWith) TemplateText() string {
+	return `{{ with .First }}{{ .DefField }}{{ else with .Second }}{{ .DefField }}{{ end }}`
+}
+
+type UndefinedWithField struct {
+	Nested  *NamedStruct
+	Message string
+}
+
+func (*UndefinedWithField) TemplateText() string {
+	return `{{ with .Nested }}{{ .Message }}{{ end }}`
+}
+
+type PipedFuncCall struct {
+	Date time.Time
+}
+
+func (*PipedFuncCall) TemplateText() string {
+	return `{{ .Date | formatDate "2006" }}`
+}
+
+type FuncCallResult struct{}
+
+func (*FuncCallResult) TemplateText() string {
+	return `{{ with nested }}{{ .DefField }}{{ end }} {{ (nested).DefField | printf "%s!" }}`
+}
+
+type UndefinedFuncCall struct{}
+
+func (*UndefinedFuncCall) TemplateText() string {
+	return `{{ undefinedFunc }}`
+}
+
+type FuncCallArgCount struct {
+	Date time.Time
+}
+
+func (*FuncCallArgCount) TemplateText() string {
+	return `{{ formatDate .Date }}`
+}
+
+type FuncCallArgType struct {
+	Name string
+}
+
+func (*FuncCallArgType) TemplateText() string {
+	return `{{ formatDate "2006" .Name }}`
+}
+
+type FuncCallResultField struct{}
+
+func (*FuncCallResultField) TemplateText() string {
+	return `{{ (nested).UndField }}`
+}
+
+type Head struct {
+	Title string
+}
+
+func (*Head) TemplateText() string {
+	return `<title>{{ .Title }}</title>`
+}
+
+type Footer struct {
+	Copyright string
+}
+
+func (*Footer) TemplateText() string {
+	return `<footer>{{ .Copyright }}</footer>`
+}
+
+type MismatchedTemplateData struct {
+	Head   `tmpl:"head"`
+	Footer Footer `tmpl:"footer"`
+}
+
+func (*MismatchedTemplateData) TemplateText() string {
+	return `{{ template "head" .Footer }}`
+}
+
+type IndentedHead struct {
+	Title string
+}
+
+func (*IndentedHead) TemplateText() string {
+	return "\n  <title>{{ .Title }}</title>\n"
+}
+
+type IndentedHeadPage struct {
+	IndentedHead `tmpl:"head"`
+}
+
+func (*IndentedHeadPage) TemplateText() string {
+	return `<html>{{ template "head" .IndentedHead }}</html>`
+}
+
+type HeadWithTypo struct {
+	Title string
+}
+
+func (*HeadWithTypo) TemplateText() string {
+	return `<title>{{ .Titel }}</title>`
+}
+
+type NestedTemplateWithTypo struct {
+	Head HeadWithTypo `tmpl:"head"`
+}
+
+func (*NestedTemplateWithTypo) TemplateText() string {
+	return `{{ template "head" .Head }}`
+}
+
+type UninvokedTemplateWithTypo struct {
+	Head HeadWithTypo `tmpl:"head"`
+}
+
+func (*UninvokedTemplateWithTypo) TemplateText() string {
+	return `<html></html>`
+}
+
+type Form struct {
+	Action string
+}
+
+type DefineWithTypo struct {
+	Form Form
+}
+
+func (*DefineWithTypo) TemplateText() string {
+	return `{{ template "form" .Form }}{{ define "form" }}<form action="{{ .Acton }}"></form>{{ end }}`
+}
+
+type UninvokedDefine struct{}
+
+func (*UninvokedDefine) TemplateText() string {
+	return `{{ define "unused" }}{{ undefinedFunc }}{{ end }}`
+}
+
+type Status string
+
+type CompatibleComparisons struct {
+	Count  int
+	Size   uint8
+	Price  float32
+	Status Status
+	Any    any
+}
+
+func (*CompatibleComparisons) TemplateText() string {
+	return `{{ if eq .Count .Size }}A{{ end }}{{ if lt .Price 1.5 }}B{{ end }}{{ if eq .Status "ok" "done" }}C{{ end }}{{ if ne .Any 1 }}D{{ end }}`
+}
+
+type IncompatibleComparison struct {
+	Count int
+}
+
+func (*IncompatibleComparison) TemplateText() string {
+	return `{{ if eq .Count "1" }}{{ end }}`
+}
+
+type InvalidOrderedComparison struct {
+	Flag bool
+}
+
+func (*InvalidOrderedComparison) TemplateText() string {
+	return `{{ if lt .Flag true }}{{ end }}`
+}
+
+type NonComparableComparison struct {
+	List []string
+}
+
+func (*NonComparableComparison) TemplateText() string {
+	return `{{ if eq .List .List }}{{ end }}`
+}
+
+type User struct {
+	Name string
+}
+
+func (u *User) Greet(greeting string, times int) string {
+	return strings.Repeat(greeting+" "+u.Name+"!", times)
+}
+
+func (u User) Initials() string {
+	return u.Name[:1]
+}
+
+func (u *User) Find(id int) (*User, error) {
+	return &User{Name: fmt.Sprintf("user%d", id)}, nil
+}
+
+func (u *User) Split() (string, string) {
+	return u.Name, u.Name
+}
+
+func (s Status) Label() string {
+	return strings.ToUpper(string(s))
+}
+
+type MethodCalls struct {
+	User      User
+	Status    Status
+	CreatedAt time.Time
+}
+
+func (*MethodCalls) TemplateText() string {
+	return `{{ .User.Greet "Hi" 2 }} {{ .User.Initials }} {{ (.User.Find 3).Name }} {{ 1 | .User.Greet "Hey" }} {{ .Status.Label }} {{ .CreatedAt.UTC.Year }}`
+}
+
+type MethodCallArgCount struct {
+	User User
+}
+
+func (*MethodCallArgCount) TemplateText() string {
+	return `{{ .User.Greet "Hi" }}`
+}
+
+type MethodCallArgType struct {
+	User User
+}
+
+func (*MethodCallArgType) TemplateText() string {
+	return `{{ .User.Greet 1 2 }}`
+}
+
+type MethodCallResultField struct {
+	User User
+}
+
+func (*MethodCallResultField) TemplateText() string {
+	return `{{ (.User.Find 3).Nmae }}`
+}
+
+type MethodCallInvalidResults struct {
+	User User
+}
+
+func (*MethodCallInvalidResults) TemplateText() string {
+	return `{{ .User.Split }}`
+}
+
+type FieldWithArguments struct {
+	User User
+}
+
+func (*FieldWithArguments) TemplateText() string {
+	return `{{ .User.Name "Hi" }}`
+}
+
+type Label struct {
+	Value string
+}
+
+type MapFields struct {
+	Labels map[string]string
+	ByID   map[int]Label
+	Named  map[string]*Label
+}
+
+func (*MapFields) TemplateText() string {
+	return `{{ .Labels.env }} {{ (index .ByID 1).Value }} {{ .Named.home.Value }} {{ range $id, $l := .ByID }}{{ $id }}={{ $l.Value }}{{ end }}`
+}
+
+type UndefinedMapValueField struct {
+	Named map[string]*Label
+}
+
+func (*UndefinedMapValueField) TemplateText() string {
+	return `{{ .Named.home.Vaule }}`
+}
+
+type NonStringMapKeyPath struct {
+	ByID map[int]Label
+}
+
+func (*NonStringMapKeyPath) TemplateText() string {
+	return `{{ .ByID.one }}`
+}
+
+type InvalidMapIndex struct {
+	ByID map[int]Label
+}
+
+func (*InvalidMapIndex) TemplateText() string {
+	return `{{ index .ByID "1" }}`
+}
+
+type Item struct {
+	Name string
+}
+
+type GenericPage[T any] struct {
+	Title string
+	Items []T
+	First T
+}
+
+func (*GenericPage[T]) TemplateText() string {
+	return `{{ .Title }}{{ range .Items }} {{ .Name }}{{ end }} {{ .First.Name }}`
+}
+
+type UndefinedGenericField[T any] struct {
+	Items []T
+}
+
+func (*UndefinedGenericField[T]) TemplateText() string {
+	return `{{ range .Items }}{{ .Nmae }}{{ end }}`
+}
+
+type Shape interface {
+	Area() float64
+}
+
+type Square struct {
+	Side float64
+}
+
+func (s Square) Area() float64 {
+	return s.Side * s.Side
+}
+
+type InterfaceField struct {
+	Shape Shape
+}
+
+func (*InterfaceField) TemplateText() string {
+	return `{{ .Shape.Area }}`
+}
+
+type UndeclaredInterfaceMethod struct {
+	Shape Shape
+}
+
+func (*UndeclaredInterfaceMethod) TemplateText() string {
+	return `{{ .Shape.Side }}`
+}
+
+type AnyField struct {
+	Any any
+}
+
+func (*AnyField) TemplateText() string {
+	return `{{ .Any.Name }}`
+}
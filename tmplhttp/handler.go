@@ -0,0 +1,276 @@
+// Package tmplhttp serves tmpl Templates over HTTP.
+package tmplhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/tylermmorton/tmpl"
+)
+
+const (
+	// DefaultContentType is the Content-Type of the responses of a Handler
+	// unless it is changed using WithContentType.
+	DefaultContentType = "text/html; charset=utf-8"
+
+	// DefaultTargetHeader is the request header a Handler reads the render
+	// targets from unless it is changed using WithTargetHeader.
+	DefaultTargetHeader = "X-Tmpl-Target"
+)
+
+// Loader loads the data a Template is rendered with for the given request.
+// Return an error created by Error to respond with a status code other than
+// http.StatusInternalServerError.
+type Loader[T tmpl.TemplateProvider] func(r *http.Request) (T, error)
+
+// StatusError is an error that makes a Handler respond with the given status
+// code. It can be returned by a Loader, or by a method or function called by
+// the Template while it is rendered.
+type StatusError struct {
+	Status int
+	Err    error
+}
+
+// Error returns a StatusError with the given status code wrapping the given
+// error. If err is nil, the status text is used as the error message.
+func Error(status int, err error) error {
+	if err == nil {
+		err = errors.New(http.StatusText(status))
+	}
+	return &StatusError{Status: status, Err: err}
+}
+
+func (e *StatusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// StatusOf returns the status code of the first StatusError in the chain of
+// the given error, or http.StatusInternalServerError if there is none.
+func StatusOf(err error) int {
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Status
+	}
+	return http.StatusInternalServerError
+}
+
+// ErrorHandler writes the response of a request that failed with the given
+// status code and error.
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, status int, err error)
+
+// defaultErrorHandler responds with the status text only, so that the details
+// of the error are not exposed to clients.
+func defaultErrorHandler(w http.ResponseWriter, r *http.Request, status int, err error) {
+	http.Error(w, http.StatusText(status), status)
+}
+
+// ErrorTemplate returns an ErrorHandler that renders the given Template with
+// the data returned by the given function. If the Template fails to render,
+// the response only contains the status text.
+func ErrorTemplate[E tmpl.TemplateProvider](t tmpl.Template[E], data func(r *http.Request, status int, err error) E) ErrorHandler {
+	return func(w http.ResponseWriter, r *http.Request, status int, err error) {
+		buf := bytes.Buffer{}
+		if err := t.RenderContext(r.Context(), &buf, data(r, status, err)); err != nil {
+			defaultErrorHandler(w, r, status, err)
+			return
+		}
+
+		if len(w.Header().Get("Content-Type")) == 0 {
+			w.Header().Set("Content-Type", DefaultContentType)
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		w.WriteHeader(status)
+		if r.Method != http.MethodHead {
+			_, _ = w.Write(buf.Bytes())
+		}
+	}
+}
+
+// HandlerOptions holds options that control the behavior of a Handler
+type HandlerOptions struct {
+	// contentType is the Content-Type of successful responses
+	contentType string
+	// targetHeader is the request header that holds the render targets
+	targetHeader string
+	// onError writes the response of failed requests
+	onError ErrorHandler
+	// renderOpts are passed to every call of RenderContext
+	renderOpts []tmpl.RenderOption
+}
+
+// HandlerOption is a function that can be used to modify the HandlerOptions
+type HandlerOption func(opts *HandlerOptions)
+
+// WithContentType sets the Content-Type of the responses of the Handler.
+// By default, it is DefaultContentType.
+func WithContentType(contentType string) HandlerOption {
+	return func(opts *HandlerOptions) {
+		opts.contentType = contentType
+	}
+}
+
+// WithTargetHeader sets the request header that holds the comma separated
+// names of the templates to render instead of the whole Template, such as
+// "HX-Target" for htmx requests. An empty name disables targeting.
+// By default, it is DefaultTargetHeader.
+func WithTargetHeader(header string) HandlerOption {
+	return func(opts *HandlerOptions) {
+		opts.targetHeader = header
+	}
+}
+
+// WithErrorHandler sets the ErrorHandler that writes the response when the
+// Loader or the Template fails. Use ErrorTemplate to render a Template.
+// By default, the response only contains the status text.
+func WithErrorHandler(fn ErrorHandler) HandlerOption {
+	return func(opts *HandlerOptions) {
+		opts.onError = fn
+	}
+}
+
+// WithRenderOptions sets the RenderOptions that are passed to the Template
+// each time it is rendered.
+func WithRenderOptions(renderOpts ...tmpl.RenderOption) HandlerOption {
+	return func(opts *HandlerOptions) {
+		opts.renderOpts = append(opts.renderOpts, renderOpts...)
+	}
+}
+
+type handler[T tmpl.TemplateProvider] struct {
+	template tmpl.Template[T]
+	load     Loader[T]
+	opts     HandlerOptions
+}
+
+// Handler returns an http.Handler that renders the given Template with the
+// data returned by the given Loader.
+//
+// The targets requested by the target header must be names returned by the
+// Template's Targets method. Requests for any other target are answered with
+// http.StatusBadRequest before the Loader is called.
+//
+// The Template is rendered into a buffer, so that a failure can still be
+// reported with an error status. Successful responses carry an ETag computed
+// from the rendered bytes, and conditional requests whose If-None-Match header
+// matches it are answered with http.StatusNotModified.
+func Handler[T tmpl.TemplateProvider](t tmpl.Template[T], load Loader[T], opts ...HandlerOption) http.Handler {
+	h := &handler[T]{
+		template: t,
+		load:     load,
+		opts: HandlerOptions{
+			contentType:  DefaultContentType,
+			targetHeader: DefaultTargetHeader,
+			onError:      defaultErrorHandler,
+		},
+	}
+
+	for _, opt := range opts {
+		opt(&h.opts)
+	}
+
+	return h
+}
+
+func (h *handler[T]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	renderOpts := h.opts.renderOpts
+	if len(h.opts.targetHeader) != 0 {
+		// responses differ by the targets requested
+		w.Header().Add("Vary", h.opts.targetHeader)
+		if targets := parseTargets(r.Header.Get(h.opts.targetHeader)); len(targets) != 0 {
+			if err := h.checkTargets(targets); err != nil {
+				h.fail(w, r, err)
+				return
+			}
+			renderOpts = append(renderOpts[:len(renderOpts):len(renderOpts)], tmpl.WithTarget(targets...))
+		}
+	}
+
+	data, err := h.load(r)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	buf := bytes.Buffer{}
+	if err := h.template.RenderContext(r.Context(), &buf, data, renderOpts...); err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	if matchesETag(r.Header.Values("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", h.opts.contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(buf.Bytes())
+	}
+}
+
+// fail passes the given error to the ErrorHandler, unless the request has
+// been cancelled by the client, in which case nobody is left to respond to.
+func (h *handler[T]) fail(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+		return
+	}
+	h.opts.onError(w, r, StatusOf(err), err)
+}
+
+// checkTargets returns a StatusError with http.StatusBadRequest if any of the
+// given targets is not a target of the Template. The targets come from the
+// client and would otherwise fail the render with an internal server error.
+func (h *handler[T]) checkTargets(targets []string) error {
+	known := make(map[string]bool)
+	for _, target := range h.template.Targets() {
+		known[target] = true
+	}
+
+	for _, target := range targets {
+		if !known[target] {
+			return Error(http.StatusBadRequest, fmt.Errorf("unknown target %q", target))
+		}
+	}
+	return nil
+}
+
+// parseTargets splits the comma separated list of targets in a header value.
+func parseTargets(header string) []string {
+	targets := make([]string, 0)
+	for _, target := range strings.Split(header, ",") {
+		if target = strings.TrimSpace(target); len(target) != 0 {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// matchesETag reports whether the values of the If-None-Match header match
+// the given ETag, using the weak comparison of RFC 9110.
+func matchesETag(values []string, etag string) bool {
+	for _, value := range values {
+		for _, candidate := range strings.Split(value, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,239 @@
+package tmplhttp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/tylermmorton/tmpl"
+)
+
+type page struct {
+	Title string
+	Body  string
+}
+
+func (*page) TemplateText() string {
+	return `{{ define "title" }}<title>{{ .Title }}</title>{{ end }}{{ template "title" . }}<p>{{ .Body }}</p>{{ .Check }}`
+}
+
+func (p *page) Check() (string, error) {
+	if p.Body == "forbidden" {
+		return "", Error(http.StatusForbidden, nil)
+	}
+	if p.Body == "broken" {
+		return "", errors.New("broken")
+	}
+	return "", nil
+}
+
+type errorPage struct {
+	Status  int
+	Message string
+}
+
+func (*errorPage) TemplateText() string {
+	return `<h1>{{ .Status }}</h1><p>{{ .Message }}</p>`
+}
+
+func loadPage(r *http.Request) (*page, error) {
+	switch body := r.URL.Query().Get("body"); body {
+	case "missing":
+		return nil, Error(http.StatusNotFound, errors.New("page not found"))
+	case "failing":
+		return nil, errors.New("database is down")
+	default:
+		return &page{Title: "Title", Body: body}, nil
+	}
+}
+
+func Test_Handler(t *testing.T) {
+	pageTemplate := tmpl.MustCompile(&page{})
+	errorTemplate := tmpl.MustCompile(&errorPage{})
+
+	renderError := ErrorTemplate(errorTemplate, func(r *http.Request, status int, err error) *errorPage {
+		return &errorPage{Status: status, Message: err.Error()}
+	})
+
+	testTable := []struct {
+		name       string
+		opts       []HandlerOption
+		method     string
+		target     string
+		header     http.Header
+		wantStatus int
+		wantBody   string
+		wantHeader http.Header
+	}{
+		{
+			name:       "Renders the template with the loaded data",
+			target:     "/?body=Hello",
+			wantStatus: http.StatusOK,
+			wantBody:   "<title>Title</title><p>Hello</p>",
+			wantHeader: http.Header{
+				"Content-Type": {DefaultContentType},
+				"Vary":         {DefaultTargetHeader},
+			},
+		},
+		{
+			name:       "Sets the configured content type",
+			opts:       []HandlerOption{WithContentType("text/plain")},
+			target:     "/?body=Hello",
+			wantStatus: http.StatusOK,
+			wantBody:   "<title>Title</title><p>Hello</p>",
+			wantHeader: http.Header{"Content-Type": {"text/plain"}},
+		},
+		{
+			name:       "Renders the targets given by the target header",
+			target:     "/?body=Hello",
+			header:     http.Header{DefaultTargetHeader: {"title, title"}},
+			wantStatus: http.StatusOK,
+			wantBody:   "<title>Title</title><title>Title</title>",
+		},
+		{
+			name:       "Renders the targets given by the configured target header",
+			opts:       []HandlerOption{WithTargetHeader("HX-Target")},
+			target:     "/?body=Hello",
+			header:     http.Header{"Hx-Target": {"title"}, DefaultTargetHeader: {"ignored"}},
+			wantStatus: http.StatusOK,
+			wantBody:   "<title>Title</title>",
+			wantHeader: http.Header{"Vary": {"HX-Target"}},
+		},
+		{
+			name:       "Responds with bad request if a target is unknown",
+			target:     "/?body=Hello",
+			header:     http.Header{DefaultTargetHeader: {"title, unknown"}},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Bad Request\n",
+		},
+		{
+			name:       "Renders the error template if a target is unknown",
+			opts:       []HandlerOption{WithErrorHandler(renderError)},
+			target:     "/?body=Hello",
+			header:     http.Header{DefaultTargetHeader: {"unknown"}},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "<h1>400</h1><p>unknown target &#34;unknown&#34;</p>",
+		},
+		{
+			name:       "Responds with not modified if any ETag matches",
+			target:     "/?body=Hello",
+			header:     http.Header{"If-None-Match": {`"other"`, "*"}},
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "Omits the body of HEAD requests",
+			method:     http.MethodHead,
+			target:     "/?body=Hello",
+			wantStatus: http.StatusOK,
+			wantHeader: http.Header{"Content-Length": {strconv.Itoa(len("<title>Title</title><p>Hello</p>"))}},
+		},
+		{
+			name:       "Responds with the status text if loading fails",
+			target:     "/?body=failing",
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   "Internal Server Error\n",
+		},
+		{
+			name:       "Responds with the status of a StatusError returned by the loader",
+			target:     "/?body=missing",
+			wantStatus: http.StatusNotFound,
+			wantBody:   "Not Found\n",
+		},
+		{
+			name:       "Renders the error template if loading fails",
+			opts:       []HandlerOption{WithErrorHandler(renderError)},
+			target:     "/?body=missing",
+			wantStatus: http.StatusNotFound,
+			wantBody:   "<h1>404</h1><p>page not found</p>",
+			wantHeader: http.Header{"Content-Type": {DefaultContentType}},
+		},
+		{
+			name:       "Renders the error template if rendering fails",
+			opts:       []HandlerOption{WithErrorHandler(renderError)},
+			target:     "/?body=broken",
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   "<h1>500</h1><p>template: *tmplhttp.page:1:101: executing &#34;*tmplhttp.page&#34; at &lt;.Check&gt;: error calling Check: broken</p>",
+		},
+		{
+			name:       "Responds with the status of a StatusError returned while rendering",
+			opts:       []HandlerOption{WithErrorHandler(renderError)},
+			target:     "/?body=forbidden",
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if len(method) == 0 {
+				method = http.MethodGet
+			}
+
+			req := httptest.NewRequest(method, tt.target, nil)
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+
+			rec := httptest.NewRecorder()
+			Handler(pageTemplate, loadPage, tt.opts...).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("ServeHTTP() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if len(tt.wantBody) != 0 && rec.Body.String() != tt.wantBody {
+				t.Errorf("ServeHTTP() body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+			if method == http.MethodHead && rec.Body.Len() != 0 {
+				t.Errorf("ServeHTTP() body = %q, want it to be empty", rec.Body.String())
+			}
+			for k, v := range tt.wantHeader {
+				if got := rec.Header().Get(k); got != v[0] {
+					t.Errorf("ServeHTTP() header %s = %q, want %q", k, got, v[0])
+				}
+			}
+		})
+	}
+}
+
+func Test_HandlerETag(t *testing.T) {
+	h := Handler(tmpl.MustCompile(&page{}), loadPage)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?body=Hello", nil))
+	etag := rec.Header().Get("ETag")
+	if len(etag) == 0 {
+		t.Fatal("ServeHTTP() did not set an ETag")
+	}
+
+	testTable := []struct {
+		name       string
+		target     string
+		wantStatus int
+	}{
+		{
+			name:       "Responds with not modified if the content did not change",
+			target:     "/?body=Hello",
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "Responds with the content if it changed",
+			target:     "/?body=Changed",
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req.Header.Set("If-None-Match", etag)
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("ServeHTTP() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
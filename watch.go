@@ -0,0 +1,36 @@
+package tmpl
+
+import (
+	"context"
+	"reflect"
+)
+
+// watch blocks until the given context is cancelled or all the given signal
+// channels are closed. Each time a signal is received, fn is called.
+func watch(ctx context.Context, signals []<-chan struct{}, fn func()) {
+	// the first case is always the context's done channel
+	cases := make([]reflect.SelectCase, 0, len(signals)+1)
+	cases = append(cases, reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(ctx.Done()),
+	})
+	for _, ch := range signals {
+		cases = append(cases, reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(ch),
+		})
+	}
+
+	for len(cases) > 1 {
+		chosen, _, ok := reflect.Select(cases)
+		if chosen == 0 {
+			return
+		} else if !ok {
+			// the watcher closed its channel, stop listening to it
+			cases = append(cases[:chosen], cases[chosen+1:]...)
+			continue
+		}
+
+		fn()
+	}
+}